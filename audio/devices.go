@@ -1,6 +1,33 @@
 package audio
 
-import "github.com/gordonklaus/portaudio"
+import (
+	"time"
+
+	"github.com/gordonklaus/portaudio"
+)
+
+// DefaultFramesPerBuffer is the buffer size used when a device has no override.
+const DefaultFramesPerBuffer = 1024
+
+// LatencyProfile selects which of the device's suggested latencies to request.
+type LatencyProfile string
+
+const (
+	LatencyLow  LatencyProfile = "low"
+	LatencyHigh LatencyProfile = "high"
+)
+
+// DeviceSettings overrides the stream parameters used for an input device.
+// Zero values fall back to the device defaults.
+type DeviceSettings struct {
+	SampleRate      float64        `json:"sample_rate,omitempty"`
+	FramesPerBuffer int            `json:"frames_per_buffer,omitempty"`
+	Latency         LatencyProfile `json:"latency,omitempty"`
+	Format          SampleFormat   `json:"format,omitempty"`
+}
+
+// Common rates offered in the settings panel, filtered per device by SupportedSampleRates
+var standardSampleRates = []float64{8000, 11025, 16000, 22050, 32000, 44100, 48000, 88200, 96000}
 
 func GetInputDeviceNames() ([]string, error) {
 	if err := Initialize(); err != nil {
@@ -35,3 +62,83 @@ func GetInputDeviceNames() ([]string, error) {
 	}
 	return names, nil
 }
+
+// SupportedSampleRates returns the standard sample rates the named device accepts
+// for mono capture.
+func SupportedSampleRates(deviceName string) ([]float64, error) {
+	device, err := findInputDevice(deviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []float64
+	for _, rate := range standardSampleRates {
+		params := streamParameters(device, DeviceSettings{SampleRate: rate})
+		if portaudio.IsFormatSupported(params, make([]float32, params.FramesPerBuffer)) == nil {
+			rates = append(rates, rate)
+		}
+	}
+	return rates, nil
+}
+
+// ValidateDeviceSettings checks that the named device can open a stream with the given settings.
+func ValidateDeviceSettings(deviceName string, settings DeviceSettings) error {
+	device, err := findInputDevice(deviceName)
+	if err != nil {
+		return err
+	}
+	params := streamParameters(device, settings)
+	return portaudio.IsFormatSupported(params, make([]float32, params.FramesPerBuffer))
+}
+
+// findInputDevice looks up a device by name, falling back to the default input device
+func findInputDevice(deviceName string) (*portaudio.DeviceInfo, error) {
+	if err := Initialize(); err != nil {
+		return nil, err
+	}
+
+	if deviceName != "" {
+		devices, err := portaudio.Devices()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if d.Name == deviceName {
+				return d, nil
+			}
+		}
+	}
+	return portaudio.DefaultInputDevice()
+}
+
+// streamParameters builds mono input parameters for device with settings applied over its defaults
+func streamParameters(device *portaudio.DeviceInfo, settings DeviceSettings) portaudio.StreamParameters {
+	sampleRate := device.DefaultSampleRate
+	if settings.SampleRate > 0 {
+		sampleRate = settings.SampleRate
+	}
+
+	framesPerBuffer := DefaultFramesPerBuffer
+	if settings.FramesPerBuffer > 0 {
+		framesPerBuffer = settings.FramesPerBuffer
+	}
+
+	var latency time.Duration
+	switch settings.Latency {
+	case LatencyHigh:
+		latency = device.DefaultHighInputLatency
+	default:
+		latency = device.DefaultLowInputLatency
+	}
+
+	return portaudio.StreamParameters{
+		Input: portaudio.StreamDeviceParameters{
+			Device:   device,
+			Channels: channels,
+			Latency:  latency,
+		},
+		SampleRate:      sampleRate,
+		FramesPerBuffer: framesPerBuffer,
+		Flags:           portaudio.ClipOff,
+	}
+}
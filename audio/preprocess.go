@@ -0,0 +1,275 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"whispergui/pcm"
+)
+
+// PreprocessOptions configures the cleanup applied to a recording before it is transcribed.
+type PreprocessOptions struct {
+	TrimSilence    bool    `json:"trim_silence"`
+	SilenceDB      float64 `json:"silence_db"`      // windows quieter than this (dBFS RMS) count as silence
+	PaddingSeconds float64 `json:"padding_seconds"` // silence kept either side of the speech
+	Normalize      bool    `json:"normalize"`
+	TargetLUFS     float64 `json:"target_lufs"`
+}
+
+// DefaultPreprocessOptions trims silent tails, which Whisper tends to fill with
+// hallucinated text, and brings speech to a consistent loudness.
+func DefaultPreprocessOptions() PreprocessOptions {
+	return PreprocessOptions{
+		TrimSilence:    true,
+		SilenceDB:      -45,
+		PaddingSeconds: 0.3,
+		Normalize:      true,
+		TargetLUFS:     -20,
+	}
+}
+
+// PreprocessReport describes what Preprocess changed.
+type PreprocessReport struct {
+	TrimmedStart float64 // seconds removed from the start
+	TrimmedEnd   float64 // seconds removed from the end
+	InputLUFS    float64 // integrated loudness before normalization
+	GainDB       float64 // gain applied by normalization
+	Limited      bool    // gain was reduced to avoid clipping
+}
+
+func (r PreprocessReport) String() string {
+	var parts []string
+	if r.TrimmedStart > 0 || r.TrimmedEnd > 0 {
+		parts = append(parts, fmt.Sprintf("trimmed %.1fs/%.1fs", r.TrimmedStart, r.TrimmedEnd))
+	}
+	if r.GainDB != 0 {
+		gain := fmt.Sprintf("%+.1f dB", r.GainDB)
+		if r.Limited {
+			gain += " (limited)"
+		}
+		parts = append(parts, gain)
+	}
+	return strings.Join(parts, ", ")
+}
+
+const (
+	silenceWindowSeconds = 0.02
+	loudnessBlockSeconds = 0.4
+	absoluteGateLUFS     = -70.0
+	relativeGateLU       = -10.0
+	peakCeiling          = 0.891 // -1 dBFS
+)
+
+// Preprocess trims leading and trailing silence from the recording and normalizes
+// its loudness, rewriting the file in place. The returned info has its frame count
+// and markers adjusted to the trimmed audio.
+func Preprocess(info RecordingInfo, opts PreprocessOptions) (RecordingInfo, PreprocessReport, error) {
+	var report PreprocessReport
+	if !opts.TrimSilence && !opts.Normalize {
+		return info, report, nil
+	}
+
+	samples, _, err := pcm.ReadWav(info.Path)
+	if err != nil {
+		return info, report, err
+	}
+
+	if opts.TrimSilence {
+		start, end := speechBounds(samples, info.SampleRate, opts.SilenceDB, opts.PaddingSeconds)
+		report.TrimmedStart = float64(start) / info.SampleRate
+		report.TrimmedEnd = float64(len(samples)-end) / info.SampleRate
+		samples = samples[start:end]
+
+		// Keep markers on the same audio they were placed against
+		var markers []uint32
+		for _, m := range info.Markers {
+			shifted := int(m) - start
+			if shifted < 0 {
+				shifted = 0
+			} else if shifted > len(samples) {
+				shifted = len(samples)
+			}
+			markers = append(markers, uint32(shifted))
+		}
+		info.Markers = markers
+	}
+
+	if opts.Normalize {
+		report.InputLUFS = integratedLoudness(samples, info.SampleRate)
+		if !math.IsInf(report.InputLUFS, -1) {
+			report.GainDB, report.Limited = normalizationGain(samples, report.InputLUFS, opts.TargetLUFS)
+			gain := float32(math.Pow(10, report.GainDB/20))
+			for i := range samples {
+				samples[i] *= gain
+			}
+		}
+	}
+
+	info.Frames = uint32(len(samples))
+	return info, report, writeWavFile(info.Path, samples, info)
+}
+
+// speechBounds returns the sample range holding windows louder than thresholdDB, widened
+// by padding. If nothing is above the threshold the whole range is returned untouched.
+func speechBounds(samples []float32, sampleRate, thresholdDB, padding float64) (int, int) {
+	window := int(silenceWindowSeconds * sampleRate)
+	if window < 1 {
+		window = 1
+	}
+	threshold := math.Pow(10, thresholdDB/20)
+
+	first, last := -1, -1
+	for pos := 0; pos < len(samples); pos += window {
+		end := min(pos+window, len(samples))
+		if rms(samples[pos:end]) >= threshold {
+			if first < 0 {
+				first = pos
+			}
+			last = end
+		}
+	}
+	if first < 0 {
+		return 0, len(samples)
+	}
+
+	pad := int(padding * sampleRate)
+	return max(first-pad, 0), min(last+pad, len(samples))
+}
+
+func rms(samples []float32) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// normalizationGain returns the gain in dB that moves inputLUFS to targetLUFS, reduced
+// if needed so the peak stays below the ceiling.
+func normalizationGain(samples []float32, inputLUFS, targetLUFS float64) (float64, bool) {
+	gainDB := targetLUFS - inputLUFS
+
+	var peak float64
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+	if peak > 0 {
+		maxGainDB := 20 * math.Log10(peakCeiling/peak)
+		if gainDB > maxGainDB {
+			return maxGainDB, true
+		}
+	}
+	return gainDB, false
+}
+
+// integratedLoudness measures mono loudness in LUFS following ITU-R BS.1770:
+// K-weighting, 400ms blocks with 75% overlap, then absolute and relative gating.
+// It returns -Inf for silence.
+func integratedLoudness(samples []float32, sampleRate float64) float64 {
+	weighted := kWeight(samples, sampleRate)
+
+	block := int(loudnessBlockSeconds * sampleRate)
+	step := block / 4
+	if block < 1 || step < 1 || len(weighted) < block {
+		// Too short for a full block; measure what we have
+		block, step = len(weighted), len(weighted)
+	}
+	if block == 0 {
+		return math.Inf(-1)
+	}
+
+	var powers []float64
+	for pos := 0; pos+block <= len(weighted); pos += step {
+		var sum float64
+		for _, s := range weighted[pos : pos+block] {
+			sum += s * s
+		}
+		powers = append(powers, sum/float64(block))
+	}
+
+	gated := gateBlocks(powers, absoluteGateLUFS)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	relativeGate := blockLoudness(mean(gated)) + relativeGateLU
+	gated = gateBlocks(gated, relativeGate)
+	if len(gated) == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(mean(gated))
+}
+
+func gateBlocks(powers []float64, gateLUFS float64) []float64 {
+	var kept []float64
+	for _, p := range powers {
+		if blockLoudness(p) > gateLUFS {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func blockLoudness(power float64) float64 {
+	return -0.691 + 10*math.Log10(power)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// kWeight applies the BS.1770 pre-filter (high shelf) and RLB high-pass filter,
+// with coefficients derived for the given sample rate.
+func kWeight(samples []float32, sampleRate float64) []float64 {
+	// Stage 1: high shelf modelling the acoustic effect of the head
+	k := math.Tan(math.Pi * 1681.974450955533 / sampleRate)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// Stage 2: high-pass removing energy below the hearing range
+	k = math.Tan(math.Pi * 38.13547087602444 / sampleRate)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		out[i] = highPass.process(shelf.process(float64(s)))
+	}
+	return out
+}
+
+// biquad is a direct form I second-order IIR filter section.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
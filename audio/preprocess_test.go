@@ -0,0 +1,146 @@
+package audio
+
+import (
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"whispergui/pcm"
+)
+
+// recording writes samples to a float32 WAV in a temporary directory.
+func recording(t *testing.T, samples []float32, sampleRate float64, markers ...uint32) RecordingInfo {
+	t.Helper()
+	info := RecordingInfo{
+		Path:       filepath.Join(t.TempDir(), "take.wav"),
+		SampleRate: sampleRate,
+		Format:     FormatFloat32,
+		Frames:     uint32(len(samples)),
+		Markers:    markers,
+	}
+	if err := writeWavFile(info.Path, samples, info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestPreprocessTrimsSilence(t *testing.T) {
+	const rate = 16000
+	// 1 s of silence, 2 s of tone, 1 s of silence
+	samples := make([]float32, 4*rate)
+	copy(samples[rate:], sine(0.1, 440, rate, 2*rate))
+
+	info := recording(t, samples, rate, rate/2, 3*rate/2, 7*rate/2)
+	opts := PreprocessOptions{TrimSilence: true, SilenceDB: -45, PaddingSeconds: 0.3}
+	out, report, err := Preprocess(info, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tone is kept with 0.3 s either side
+	if math.Abs(report.TrimmedStart-0.7) > 0.021 || math.Abs(report.TrimmedEnd-0.7) > 0.021 {
+		t.Errorf("trimmed %.3fs/%.3fs, want 0.7s/0.7s", report.TrimmedStart, report.TrimmedEnd)
+	}
+	got, _, err := pcm.ReadWav(out.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := int(report.TrimmedStart * rate)
+	if len(got) != int(out.Frames) || !slices.Equal(got, samples[start:start+len(got)]) {
+		t.Errorf("file holds %d samples, frames %d; want samples %d on, unchanged", len(got), out.Frames, start)
+	}
+
+	// Markers stay on the same audio; those in the trimmed silence move to the nearest end
+	want := []uint32{0, uint32(3*rate/2 - start), uint32(len(got))}
+	if !slices.Equal(out.Markers, want) {
+		t.Errorf("markers %v, want %v", out.Markers, want)
+	}
+}
+
+func TestSpeechBounds(t *testing.T) {
+	const rate = 16000
+	silence := make([]float32, rate)
+	if start, end := speechBounds(silence, rate, -45, 0.3); start != 0 || end != len(silence) {
+		t.Errorf("silence trimmed to %d:%d, want it left alone", start, end)
+	}
+
+	// Tone right at the edges cannot be padded past them
+	tone := sine(0.1, 440, rate, rate)
+	if start, end := speechBounds(tone, rate, -45, 0.3); start != 0 || end != len(tone) {
+		t.Errorf("tone trimmed to %d:%d", start, end)
+	}
+
+	// Noise below the threshold counts as silence
+	quiet := slices.Concat(sine(0.001, 440, rate, rate), tone)
+	if start, _ := speechBounds(quiet, rate, -45, 0); start != rate {
+		t.Errorf("speech starts at %d, want %d", start, rate)
+	}
+}
+
+func TestIntegratedLoudness(t *testing.T) {
+	// BS.1770 is calibrated so a full-scale 1 kHz sine measures -3.01 LUFS
+	for _, rate := range []float64{16000, 48000} {
+		for _, dbfs := range []float64{0, -20, -40} {
+			samples := sine(math.Pow(10, dbfs/20), 1000, rate, 3*int(rate))
+			want := dbfs - 3.01
+			if got := integratedLoudness(samples, rate); math.Abs(got-want) > 0.1 {
+				t.Errorf("%v Hz, %v dBFS: %.2f LUFS, want %.2f", rate, dbfs, got, want)
+			}
+		}
+	}
+
+	if got := integratedLoudness(make([]float32, 48000), 48000); !math.IsInf(got, -1) {
+		t.Errorf("silence measured %v LUFS, want -Inf", got)
+	}
+}
+
+func TestPreprocessNormalizes(t *testing.T) {
+	const rate = 48000
+	info := recording(t, sine(0.01, 1000, rate, 3*rate), rate) // -43 LUFS
+	opts := PreprocessOptions{Normalize: true, TargetLUFS: -20}
+	out, report, err := Preprocess(info, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Limited || math.Abs(report.InputLUFS+43.01) > 0.1 || math.Abs(report.GainDB-23.01) > 0.1 {
+		t.Errorf("report %+v, want 23 dB of gain from -43 LUFS", report)
+	}
+	got, _, err := pcm.ReadWav(out.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lufs := integratedLoudness(got, rate); math.Abs(lufs+20) > 0.1 {
+		t.Errorf("normalized to %.2f LUFS, want -20", lufs)
+	}
+}
+
+func TestPreprocessLimitsPeaks(t *testing.T) {
+	const rate = 48000
+	// A quiet tone with one loud click: reaching the target would clip the click
+	samples := sine(0.01, 1000, rate, 3*rate)
+	samples[rate] = 0.5
+	info := recording(t, samples, rate)
+	out, report, err := Preprocess(info, PreprocessOptions{Normalize: true, TargetLUFS: -20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Limited {
+		t.Errorf("report %+v, want the gain limited", report)
+	}
+	if want := 20 * math.Log10(peakCeiling/0.5); math.Abs(report.GainDB-want) > 0.01 {
+		t.Errorf("gain %.2f dB, want %.2f", report.GainDB, want)
+	}
+	got, _, err := pcm.ReadWav(out.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peak float64
+	for _, s := range got {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+	if peak > peakCeiling+1e-6 {
+		t.Errorf("peak %v above the %v ceiling", peak, peakCeiling)
+	}
+}
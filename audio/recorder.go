@@ -1,7 +1,6 @@
 package audio
 
 import (
-	"encoding/binary"
 	"math"
 	"os"
 	"sync"
@@ -45,63 +44,63 @@ func Terminate() {
 	}
 }
 
+// RecordingInfo describes a finished recording, including any input
+// overflows that left gaps in the captured audio.
+type RecordingInfo struct {
+	Path          string
+	SampleRate    float64
+	Format        SampleFormat
+	Frames        uint32
+	Overflows     int
+	DroppedFrames int
+	Markers       []uint32 // frame offsets of user markers, in order
+}
+
+// MarkerSeconds returns the marker positions in seconds from the start of the recording.
+func (r RecordingInfo) MarkerSeconds() []float64 {
+	var secs []float64
+	for _, m := range r.Markers {
+		secs = append(secs, float64(m)/r.SampleRate)
+	}
+	return secs
+}
+
 var (
 	monitorStream     *portaudio.Stream
 	monitorFile       *os.File
+	monitorPath       string
 	monitorDataSize   uint32
 	monitorSampleRate float64
+	monitorFormat     SampleFormat
+	monitorFileFormat SampleFormat
 	monitorRecording  bool
+	monitorOverflows  int
+	monitorDropped    int
+	monitorMarkers    []uint32
 	monitorMux        sync.Mutex
 	monitorDone       chan struct{}
 	monitorFinished   chan struct{}
 )
 
-func StartMonitoring(deviceName string, getVolumeGain func() float64, onLevel func(float64)) error {
+// StartMonitoring opens the input device and continuously reports its level.
+// onDropped is called with the running total of dropped frames whenever the
+// input overflows; the stream is kept alive in that case. onError is called
+// once if the stream fails for any other reason, after which monitoring stops.
+// settings override the device's default sample rate, buffer size and latency.
+func StartMonitoring(deviceName string, settings DeviceSettings, getVolumeGain func() float64, onLevel func(float64), onDropped func(int), onError func(error)) error {
 	StopMonitoring() // Ensure previous monitor is closed
 
-	if err := Initialize(); err != nil {
-		return err
-	}
-
-	devices, err := portaudio.Devices()
+	device, err := findInputDevice(deviceName)
 	if err != nil {
 		return err
 	}
 
-	var device *portaudio.DeviceInfo
-	if deviceName == "" {
-		device, err = portaudio.DefaultInputDevice()
-		if err != nil {
-			return err
-		}
-	} else {
-		for _, d := range devices {
-			if d.Name == deviceName {
-				device = d
-				break
-			}
-		}
-		if device == nil {
-			device, err = portaudio.DefaultInputDevice()
-			if err != nil {
-				return err
-			}
-		}
-	}
+	params := streamParameters(device, settings)
+	monitorSampleRate = params.SampleRate
+	monitorFormat = settings.Format
 
-	monitorSampleRate = device.DefaultSampleRate
-
-	in := make([]int16, 1024)
-	params := portaudio.StreamParameters{
-		Input: portaudio.StreamDeviceParameters{
-			Device:   device,
-			Channels: channels,
-			Latency:  device.DefaultLowInputLatency,
-		},
-		SampleRate:      monitorSampleRate,
-		FramesPerBuffer: len(in),
-		Flags:           portaudio.ClipOff,
-	}
+	// Capture as float so gain does not clip or quantise before the write
+	in := make([]float32, params.FramesPerBuffer)
 
 	monitorStream, err = portaudio.OpenStream(params, in)
 	if err != nil {
@@ -124,37 +123,41 @@ func StartMonitoring(deviceName string, getVolumeGain func() float64, onLevel fu
 				return
 			default:
 				if err := monitorStream.Read(); err != nil {
-					return
+					if err != portaudio.InputOverflowed {
+						if onError != nil {
+							onError(err)
+						}
+						return
+					}
+
+					// Frames were lost before this buffer, but the buffer
+					// itself is valid. PortAudio does not say how many were
+					// lost, so count one buffer's worth per overflow.
+					monitorMux.Lock()
+					monitorOverflows++
+					monitorDropped += len(in)
+					dropped := monitorDropped
+					monitorMux.Unlock()
+
+					if onDropped != nil {
+						onDropped(dropped)
+					}
 				}
 
-				var sumSquares float64
 				volGain := 1.0
 				if getVolumeGain != nil {
 					volGain = getVolumeGain()
 				}
 
 				// Copy in order to hold the lock briefly if recording
-				var recordingBuffer []int16
-
-				for _, sample := range in {
-					scaled := float64(sample) * volGain
-					if scaled > 32767 {
-						scaled = 32767
-					} else if scaled < -32768 {
-						scaled = -32768
-					}
-					finalSample := int16(scaled)
-
-					sumSquares += float64(finalSample) * float64(finalSample)
-					recordingBuffer = append(recordingBuffer, finalSample)
-				}
+				recordingBuffer, sumSquares := applyGain(in, volGain)
 
 				// If we are actively recording, write to file under mutex
 				monitorMux.Lock()
 				if monitorRecording && monitorFile != nil {
-					for _, s := range recordingBuffer {
-						binary.Write(monitorFile, binary.LittleEndian, s)
-						monitorDataSize += 2
+					data := encodeSamples(recordingBuffer, monitorFileFormat)
+					if n, err := monitorFile.Write(data); err == nil {
+						monitorDataSize += uint32(n)
 					}
 				}
 				monitorMux.Unlock()
@@ -166,7 +169,7 @@ func StartMonitoring(deviceName string, getVolumeGain func() float64, onLevel fu
 						ms := sumSquares / float64(len(in))
 						rmsValue := math.Sqrt(ms)
 						// Highly sensitive mapped RMS, clamped 0.0-1.0
-						rms = (rmsValue / 32768.0) * 15.0
+						rms = rmsValue * 15.0
 					}
 
 					if rms < 0 {
@@ -183,6 +186,19 @@ func StartMonitoring(deviceName string, getVolumeGain func() float64, onLevel fu
 	return nil
 }
 
+// applyGain returns a copy of in scaled by gain, and the sum of its squared samples.
+// Nothing is clamped, so overs survive until the samples are written.
+func applyGain(in []float32, gain float64) ([]float32, float64) {
+	out := make([]float32, len(in))
+	var sumSquares float64
+	for i, sample := range in {
+		scaled := float64(sample) * gain
+		sumSquares += scaled * scaled
+		out[i] = float32(scaled)
+	}
+	return out, sumSquares
+}
+
 func StopMonitoring() {
 	if monitorStream != nil {
 		close(monitorDone)
@@ -213,46 +229,58 @@ func StartRecording(path string) error {
 	}
 
 	monitorFile = file
+	monitorFileFormat = monitorFormat
+	monitorPath = path
 	monitorDataSize = 0
+	monitorOverflows = 0
+	monitorDropped = 0
+	monitorMarkers = nil
 	monitorRecording = true
 	return nil
 }
 
-func StopRecording() error {
+// AddMarker records a marker at the current end of the recording and returns its
+// 1-based number. It returns false if no recording is in progress.
+func AddMarker() (int, bool) {
 	monitorMux.Lock()
 	defer monitorMux.Unlock()
 
 	if !monitorRecording {
-		return nil
+		return 0, false
+	}
+	frame := monitorDataSize / uint32(monitorFileFormat.bytesPerSample())
+	monitorMarkers = append(monitorMarkers, frame)
+	return len(monitorMarkers), true
+}
+
+func StopRecording() (RecordingInfo, error) {
+	monitorMux.Lock()
+	defer monitorMux.Unlock()
+
+	if !monitorRecording {
+		return RecordingInfo{}, nil
 	}
 	monitorRecording = false
 
+	info := RecordingInfo{
+		Path:          monitorPath,
+		SampleRate:    monitorSampleRate,
+		Format:        monitorFileFormat,
+		Frames:        monitorDataSize / uint32(monitorFileFormat.bytesPerSample()),
+		Overflows:     monitorOverflows,
+		DroppedFrames: monitorDropped,
+		Markers:       monitorMarkers,
+	}
+
 	if monitorFile != nil {
+		// Record markers and gaps in the file itself so they are not lost with the UI state
+		extraSize := writeTrailer(monitorFile, info)
+
 		monitorFile.Seek(0, 0)
-		writeWavHeader(monitorFile, monitorDataSize, monitorSampleRate)
+		writeWavHeader(monitorFile, monitorDataSize, extraSize, monitorSampleRate, monitorFileFormat)
 		err := monitorFile.Close()
 		monitorFile = nil
-		return err
+		return info, err
 	}
-	return nil
-}
-
-func writeWavHeader(f *os.File, dataSize uint32, sampleRate float64) {
-	var header [44]byte
-
-	copy(header[0:], "RIFF")
-	binary.LittleEndian.PutUint32(header[4:], 36+dataSize)
-	copy(header[8:], "WAVE")
-	copy(header[12:], "fmt ")
-	binary.LittleEndian.PutUint32(header[16:], 16)
-	binary.LittleEndian.PutUint16(header[20:], 1)
-	binary.LittleEndian.PutUint16(header[22:], channels)
-	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
-	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate)*2)
-	binary.LittleEndian.PutUint16(header[32:], 2)
-	binary.LittleEndian.PutUint16(header[34:], 16)
-	copy(header[36:], "data")
-	binary.LittleEndian.PutUint32(header[40:], dataSize)
-
-	f.Write(header[:])
+	return info, nil
 }
@@ -0,0 +1,164 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"whispergui/pcm"
+)
+
+// SampleFormat is the sample encoding written to recordings. Capture and
+// gain are always processed as float32; conversion happens at write time.
+type SampleFormat string
+
+const (
+	FormatInt16   SampleFormat = "int16"
+	FormatFloat32 SampleFormat = "float32"
+)
+
+func (f SampleFormat) bytesPerSample() int {
+	if f == FormatFloat32 {
+		return 4
+	}
+	return 2
+}
+
+// encodeSamples converts float samples in [-1, 1] to little-endian bytes in
+// the given format, clamping only where the format cannot represent overs.
+func encodeSamples(samples []float32, format SampleFormat) []byte {
+	buf := make([]byte, len(samples)*format.bytesPerSample())
+	for i, s := range samples {
+		if format == FormatFloat32 {
+			binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(s))
+			continue
+		}
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(pcm.ToInt16(s)))
+	}
+	return buf
+}
+
+// writeTrailer appends the chunks that follow the audio data (markers and a
+// comment describing input overflows) and returns the number of bytes written.
+func writeTrailer(f *os.File, info RecordingInfo) uint32 {
+	var extraSize uint32
+	if len(info.Markers) > 0 {
+		extraSize += writeCueChunk(f, info.Markers)
+	}
+	if info.Overflows > 0 {
+		comment := fmt.Sprintf("Input overflowed %d times, about %d frames dropped", info.Overflows, info.DroppedFrames)
+		extraSize += writeInfoChunk(f, comment)
+	}
+	return extraSize
+}
+
+// writeWavFile writes samples and the trailer for info to path, replacing any existing file.
+// The file is written beside path and renamed over it once complete, so a failure leaves
+// the existing file as it was.
+func writeWavFile(path string, samples []float32, info RecordingInfo) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*.wav")
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	data := encodeSamples(samples, info.Format)
+	writeWavHeader(f, uint32(len(data)), 0, info.SampleRate, info.Format) // placeholder sizes
+	if _, err := f.Write(data); err != nil {
+		return fail(err)
+	}
+	extraSize := writeTrailer(f, info)
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return fail(err)
+	}
+	writeWavHeader(f, uint32(len(data)), extraSize, info.SampleRate, info.Format)
+	// The header and trailer writers do not report errors, so check the file is all there
+	if st, err := f.Stat(); err != nil || st.Size() != 44+int64(len(data))+int64(extraSize) {
+		return fail(errors.New("incomplete write"))
+	}
+	// CreateTemp makes the file private; recordings are as readable as os.Create makes them
+	f.Chmod(0644)
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// writeCueChunk appends a cue chunk with one point per marker frame at the
+// current file position and returns the number of bytes written.
+func writeCueChunk(f *os.File, markers []uint32) uint32 {
+	chunk := make([]byte, 12+24*len(markers))
+	copy(chunk[0:], "cue ")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(4+24*len(markers)))
+	binary.LittleEndian.PutUint32(chunk[8:], uint32(len(markers)))
+
+	for i, frame := range markers {
+		point := chunk[12+24*i:]
+		binary.LittleEndian.PutUint32(point[0:], uint32(i+1)) // cue point ID
+		binary.LittleEndian.PutUint32(point[4:], frame)       // play order position
+		copy(point[8:], "data")
+		binary.LittleEndian.PutUint32(point[12:], 0) // chunk start
+		binary.LittleEndian.PutUint32(point[16:], 0) // block start
+		binary.LittleEndian.PutUint32(point[20:], frame)
+	}
+
+	n, _ := f.Write(chunk)
+	return uint32(n)
+}
+
+// writeInfoChunk appends a LIST/INFO chunk holding an ICMT comment at the
+// current file position and returns the number of bytes written.
+func writeInfoChunk(f *os.File, comment string) uint32 {
+	text := append([]byte(comment), 0)
+	padded := len(text) + len(text)%2 // chunks are word aligned
+
+	chunk := make([]byte, 20+padded)
+	copy(chunk[0:], "LIST")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(12+padded))
+	copy(chunk[8:], "INFO")
+	copy(chunk[12:], "ICMT")
+	binary.LittleEndian.PutUint32(chunk[16:], uint32(len(text)))
+	copy(chunk[20:], text)
+
+	n, _ := f.Write(chunk)
+	return uint32(n)
+}
+
+func writeWavHeader(f *os.File, dataSize uint32, extraSize uint32, sampleRate float64, format SampleFormat) {
+	var header [44]byte
+
+	audioFormat := uint16(1) // PCM
+	if format == FormatFloat32 {
+		audioFormat = 3 // IEEE float
+	}
+	blockAlign := uint16(channels * format.bytesPerSample())
+
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], 36+dataSize+extraSize)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], audioFormat)
+	binary.LittleEndian.PutUint16(header[22:], channels)
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate)*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(header[32:], blockAlign)
+	binary.LittleEndian.PutUint16(header[34:], blockAlign*8)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], dataSize)
+
+	f.Write(header[:])
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"whispergui/pcm"
+)
+
+func sine(amplitude, freq, sampleRate float64, n int) []float32 {
+	out := make([]float32, n)
+	for i := range out {
+		out[i] = float32(amplitude * math.Sin(2*math.Pi*freq*float64(i)/sampleRate))
+	}
+	return out
+}
+
+// snrDB compares got against the exact signal want.
+func snrDB(want []float64, got []float32) float64 {
+	var signal, noise float64
+	for i, w := range want {
+		d := float64(got[i]) - w
+		signal += w * w
+		noise += d * d
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func decodeInt16(data []byte) []float32 {
+	out := make([]float32, len(data)/2)
+	for i := range out {
+		out[i] = float32(int16(binary.LittleEndian.Uint16(data[i*2:]))) / 32768
+	}
+	return out
+}
+
+func TestFloatGainKeepsQuietSignalsClean(t *testing.T) {
+	const gain = 16
+	input := sine(0.002, 440, 48000, 48000) // about -54 dBFS, as from a quiet microphone
+	want := make([]float64, len(input))
+	for i, s := range input {
+		want[i] = float64(s) * gain
+	}
+
+	// Before: captured as int16, then gain applied to the quantized samples and clamped
+	old := make([]float32, len(input))
+	for i, s := range input {
+		scaled := float64(pcm.ToInt16(s)) * gain
+		scaled = math.Max(-32768, math.Min(32767, scaled))
+		old[i] = float32(scaled) / 32768
+	}
+
+	// Now: gain applied in float, quantized once when written
+	gained, _ := applyGain(input, gain)
+	current := decodeInt16(encodeSamples(gained, FormatInt16))
+
+	oldSNR, newSNR := snrDB(want, old), snrDB(want, current)
+	// Quantizing after the gain instead of before keeps the noise gain times smaller
+	if improvement := newSNR - oldSNR; improvement < 20*math.Log10(gain)-3 {
+		t.Errorf("SNR %.1f dB, was %.1f dB: improved by %.1f dB, want about %.1f", newSNR, oldSNR, improvement, 20*math.Log10(gain))
+	}
+}
+
+func TestFloat32KeepsOvers(t *testing.T) {
+	input := sine(0.5, 440, 48000, 480)
+	gained, sumSquares := applyGain(input, 3)
+
+	var peak float32
+	var want float64
+	for i, s := range gained {
+		if s != input[i]*3 {
+			t.Fatalf("sample %d: %v, want %v", i, s, input[i]*3)
+		}
+		peak = max(peak, s)
+		want += float64(s) * float64(s)
+	}
+	if peak <= 1 {
+		t.Fatalf("peak %v, want the gain to push it above 1", peak)
+	}
+	if math.Abs(sumSquares-want) > 1e-6*want {
+		t.Errorf("sum of squares %v, want %v", sumSquares, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "take.wav")
+	if err := writeWavFile(path, gained, RecordingInfo{SampleRate: 48000, Format: FormatFloat32}); err != nil {
+		t.Fatal(err)
+	}
+	got, rate, err := pcm.ReadWav(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 48000 || len(got) != len(gained) {
+		t.Fatalf("read %d samples at %v Hz", len(got), rate)
+	}
+	for i := range got {
+		if got[i] != gained[i] {
+			t.Fatalf("sample %d read back as %v, want %v unclamped", i, got[i], gained[i])
+		}
+	}
+
+	// 16-bit recordings can only clamp
+	for i, s := range decodeInt16(encodeSamples(gained, FormatInt16)) {
+		if s > 1 || s < -1 {
+			t.Fatalf("int16 sample %d is %v", i, s)
+		}
+	}
+}
+
+func TestWriteWavFileReplaces(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "take.wav")
+	info := RecordingInfo{SampleRate: 16000, Format: FormatInt16, Markers: []uint32{3}, Overflows: 1}
+	if err := writeWavFile(path, sine(0.5, 440, 16000, 1600), info); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeWavFile(path, make([]float32, 800), info); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := pcm.ReadWav(path)
+	if err != nil || len(got) != 800 {
+		t.Fatalf("read %d samples, %v; want the 800 written last", len(got), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the recording", len(entries))
+	}
+
+	// Failing to create the file beside the target is reported
+	if err := writeWavFile(filepath.Join(dir, "missing", "take.wav"), got, info); err == nil {
+		t.Error("wrote into a directory that does not exist")
+	}
+}
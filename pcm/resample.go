@@ -0,0 +1,133 @@
+package pcm
+
+import (
+	"context"
+	"math"
+)
+
+const (
+	// Half-width of the interpolation kernel in zero crossings. Larger is sharper but slower.
+	sincZeroCrossings = 16
+	// Largest number of kernel phases precomputed; rarer rate pairs are computed per sample.
+	maxPhases = 4096
+	// Output samples between checks for cancellation.
+	cancelCheckInterval = 16384
+)
+
+// Resample converts samples from one rate to another using windowed-sinc interpolation,
+// low-pass filtering first when downsampling so speech does not alias.
+func Resample(samples []float32, from, to float64) []float32 {
+	out, _ := ResampleContext(context.Background(), samples, from, to)
+	return out
+}
+
+// ResampleContext is like Resample but gives up with ctx.Err() once ctx is done, for
+// recordings long enough that resampling takes noticeable time.
+func ResampleContext(ctx context.Context, samples []float32, from, to float64) ([]float32, error) {
+	if from == to || len(samples) == 0 {
+		return samples, nil
+	}
+
+	k := newKernel(from, to)
+	out := make([]float32, int(float64(len(samples))*k.ratio))
+	for i := range out {
+		if i%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		out[i] = k.at(samples, i)
+	}
+	return out, nil
+}
+
+// kernel interpolates output samples for one pair of rates. When the rates are whole
+// numbers, output sample i sits at one of up phases between input samples, and the
+// weights for every phase are computed once.
+type kernel struct {
+	ratio     float64
+	cutoff    float64 // relative to the input Nyquist frequency
+	halfWidth float64 // in input samples
+	taps      int     // weights per phase
+
+	up, down int       // ratio as a reduced fraction, if the table is used
+	table    []float64 // taps weights for each of the up phases
+}
+
+func newKernel(from, to float64) *kernel {
+	k := &kernel{ratio: to / from}
+	k.cutoff = math.Min(1, k.ratio)
+	k.halfWidth = float64(sincZeroCrossings) / k.cutoff
+	k.taps = 2 * int(math.Ceil(k.halfWidth))
+
+	if from != math.Trunc(from) || to != math.Trunc(to) || from <= 0 || to <= 0 {
+		return k
+	}
+	g := gcd(int(from), int(to))
+	up, down := int(to)/g, int(from)/g
+	if up > maxPhases {
+		return k
+	}
+	k.up, k.down = up, down
+	k.table = make([]float64, up*k.taps)
+	for p := range up {
+		row := k.table[p*k.taps : (p+1)*k.taps]
+		frac := float64(p) / float64(up)
+		for t := range row {
+			row[t] = k.weight(float64(t-k.taps/2+1) - frac)
+		}
+	}
+	return k
+}
+
+// at returns output sample i.
+func (k *kernel) at(samples []float32, i int) float32 {
+	if k.table == nil {
+		center := float64(i) / k.ratio
+		lo := int(math.Ceil(center - k.halfWidth))
+		hi := int(math.Floor(center + k.halfWidth))
+
+		var sum float64
+		for j := max(lo, 0); j <= hi && j < len(samples); j++ {
+			sum += float64(samples[j]) * k.weight(float64(j)-center)
+		}
+		return float32(sum)
+	}
+
+	// Output sample i lies between input samples n and n+1, p/up of the way along
+	n, p := i*k.down/k.up, i*k.down%k.up
+	row := k.table[p*k.taps : (p+1)*k.taps]
+	start := n - k.taps/2 + 1
+	var sum float64
+	for t := max(0, -start); t < len(row) && start+t < len(samples); t++ {
+		sum += float64(samples[start+t]) * row[t]
+	}
+	return float32(sum)
+}
+
+// weight is the kernel at x input samples from the output sample.
+func (k *kernel) weight(x float64) float64 {
+	return k.cutoff * sinc(k.cutoff*x) * hann(x/k.halfWidth)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// hann is the Hann window over [-1, 1]
+func hann(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return 0.5 + 0.5*math.Cos(math.Pi*x)
+}
@@ -0,0 +1,44 @@
+package pcm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestResampleTableMatchesDirect(t *testing.T) {
+	for _, rates := range [][2]float64{{48000, 16000}, {44100, 16000}, {22050, 16000}, {8000, 16000}} {
+		from, to := rates[0], rates[1]
+		in := make([]float32, int(from/2))
+		for i := range in {
+			x := float64(i) / from
+			in[i] = float32(0.5*math.Sin(2*math.Pi*300*x) + 0.2*math.Sin(2*math.Pi*2500*x))
+		}
+
+		k := newKernel(from, to)
+		if k.table == nil {
+			t.Fatalf("%v -> %v: no table", from, to)
+		}
+		direct := *k
+		direct.table = nil
+
+		out := Resample(in, from, to)
+		if want := int(float64(len(in)) * to / from); len(out) != want {
+			t.Fatalf("%v -> %v: %d samples, want %d", from, to, len(out), want)
+		}
+		for i := range out {
+			if want := direct.at(in, i); math.Abs(float64(out[i]-want)) > 1e-5 {
+				t.Fatalf("%v -> %v: sample %d is %v, want %v", from, to, i, out[i], want)
+			}
+		}
+	}
+}
+
+func TestResampleContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ResampleContext(ctx, make([]float32, 48000), 48000, 16000); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
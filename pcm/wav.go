@@ -0,0 +1,111 @@
+// Package pcm reads, writes and resamples mono WAV audio without depending on
+// the capture stack, so the transcription backends can prepare audio too.
+package pcm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ReadWav reads a mono 16-bit PCM or 32-bit float WAV file as float samples in [-1, 1].
+func ReadWav(path string) ([]float32, float64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(raw) < 12 || string(raw[0:4]) != "RIFF" || string(raw[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var float bool
+	var sampleRate float64
+	var data []byte
+	for pos := 12; pos+8 <= len(raw); {
+		id := string(raw[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(raw[pos+4:]))
+		body := raw[pos+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("truncated fmt chunk")
+			}
+			audioFormat := binary.LittleEndian.Uint16(body[0:])
+			channels := binary.LittleEndian.Uint16(body[2:])
+			bits := binary.LittleEndian.Uint16(body[14:])
+			sampleRate = float64(binary.LittleEndian.Uint32(body[4:]))
+			switch {
+			case channels != 1:
+				return nil, 0, fmt.Errorf("unsupported channel count %d", channels)
+			case audioFormat == 1 && bits == 16:
+				float = false
+			case audioFormat == 3 && bits == 32:
+				float = true
+			default:
+				return nil, 0, fmt.Errorf("unsupported WAV format %d with %d bits", audioFormat, bits)
+			}
+		case "data":
+			data = body
+		}
+		pos += 8 + size + size%2
+	}
+	if sampleRate == 0 || data == nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	if float {
+		samples := make([]float32, len(data)/4)
+		for i := range samples {
+			samples[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:]))
+		}
+		return samples, sampleRate, nil
+	}
+
+	samples := make([]float32, len(data)/2)
+	for i := range samples {
+		samples[i] = float32(int16(binary.LittleEndian.Uint16(data[i*2:]))) / 32768
+	}
+	return samples, sampleRate, nil
+}
+
+// WriteWav writes samples to path as a mono 16-bit PCM WAV file.
+func WriteWav(path string, samples []float32, sampleRate float64) error {
+	data := make([]byte, 44+len(samples)*2)
+	copy(data[0:], "RIFF")
+	binary.LittleEndian.PutUint32(data[4:], uint32(36+len(samples)*2))
+	copy(data[8:], "WAVE")
+	copy(data[12:], "fmt ")
+	binary.LittleEndian.PutUint32(data[16:], 16)
+	binary.LittleEndian.PutUint16(data[20:], 1)
+	binary.LittleEndian.PutUint16(data[22:], 1)
+	binary.LittleEndian.PutUint32(data[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(data[28:], uint32(sampleRate)*2)
+	binary.LittleEndian.PutUint16(data[32:], 2)
+	binary.LittleEndian.PutUint16(data[34:], 16)
+	copy(data[36:], "data")
+	binary.LittleEndian.PutUint32(data[40:], uint32(len(samples)*2))
+
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[44+i*2:], uint16(ToInt16(s)))
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ToInt16 converts a float sample to 16-bit PCM, clamping values outside [-1, 1].
+func ToInt16(s float32) int16 {
+	scaled := math.Round(float64(s) * 32768)
+	if scaled > 32767 {
+		scaled = 32767
+	} else if scaled < -32768 {
+		scaled = -32768
+	}
+	return int16(scaled)
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"image/color"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"whispergui/audio"
@@ -16,21 +19,28 @@ import (
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/layout"
 	"fyne.io/fyne/v2/widget"
 	"github.com/atotto/clipboard"
 )
 
 var (
-	isRecording      bool
-	selectedModel    string = "small"
-	lastWorkingModel string = "small"
+	isRecording            bool
+	selectedBackend        string
+	selectedModel          string = "small"
+	selectedComputeType    string
+	lastWorkingBackend     string
+	lastWorkingModel       string = "small"
+	lastWorkingComputeType string
 )
 
 func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 	fmt.Println("Launching Whisper GUI...")
 
-	a := app.New()
+	// A unique ID is required for preferences to persist between runs
+	a := app.NewWithID("io.github.emancipat3r.whisper-gui")
+	prefs := a.Preferences()
 	w := a.NewWindow("Whisper Voice-to-Text")
 	w.Resize(fyne.NewSize(700, 500))
 
@@ -89,10 +99,88 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 	readyLed, readyIndicator := createLed(redColor)
 	readyStatusLabel := widget.NewLabel("Backend: Loading...")
 
-	// Model Selection Dropdown
-	modelSelect := widget.NewSelect([]string{"tiny", "base", "small", "medium", "large"}, nil)
+	// Engine Selection Dropdown, listing every registered backend
+	backendNames := whisper.Backends()
+	// Registration order follows file names, so the default is chosen by name
+	fallbackBackend := defaultBackend
+	if !slices.Contains(backendNames, fallbackBackend) {
+		fallbackBackend = backendNames[0]
+	}
+	selectedBackend = prefs.StringWithFallback("backend", fallbackBackend)
+	caps, err := whisper.BackendCapabilities(selectedBackend)
+	if err != nil {
+		selectedBackend = fallbackBackend
+		caps, _ = whisper.BackendCapabilities(selectedBackend)
+	}
+	// The precision last used with each backend is remembered, if the backend offers a choice
+	preferredComputeType := func(backend string) string {
+		caps, err := whisper.BackendCapabilities(backend)
+		if err != nil || len(caps.ComputeTypes) == 0 {
+			return ""
+		}
+		return prefs.StringWithFallback("compute_type."+backend, caps.ComputeTypes[0])
+	}
+
+	selectedModel = caps.DefaultModel
+	selectedComputeType = preferredComputeType(selectedBackend)
+	lastWorkingBackend, lastWorkingModel, lastWorkingComputeType = selectedBackend, selectedModel, selectedComputeType
+	backendSelect := widget.NewSelect(backendNames, nil)
+	backendSelect.SetSelected(selectedBackend)
+
+	// Model Selection Dropdown, offering the models of the selected backend
+	modelSelect := widget.NewSelect(caps.Models, nil)
 	modelSelect.SetSelected(selectedModel)
 
+	// Precision Dropdown, only shown for backends that support a choice
+	computeLabel := widget.NewLabel("Precision:")
+	computeSelect := widget.NewSelect(caps.ComputeTypes, nil)
+	computeSelect.SetSelected(selectedComputeType)
+
+	// Point the dropdowns at backend, model and precision; selected* are updated first so OnChanged is a no-op
+	showBackend := func(backend, model, computeType string) {
+		selectedBackend, selectedModel, selectedComputeType = backend, model, computeType
+		caps, _ := whisper.BackendCapabilities(backend)
+		backendSelect.SetSelected(backend)
+		modelSelect.SetOptions(caps.Models)
+		modelSelect.SetSelected(model)
+		computeSelect.SetOptions(caps.ComputeTypes)
+		computeSelect.SetSelected(computeType)
+		if len(caps.ComputeTypes) > 0 {
+			computeLabel.Show()
+			computeSelect.Show()
+		} else {
+			computeLabel.Hide()
+			computeSelect.Hide()
+		}
+	}
+	showBackend(selectedBackend, selectedModel, selectedComputeType)
+
+	// Language Dropdown; auto-detection misfires on short clips, so the language can be pinned
+	languageOptions := []string{autoLanguage}
+	for _, l := range whisper.Languages {
+		languageOptions = append(languageOptions, l.Name)
+	}
+	languageSelect := widget.NewSelect(languageOptions, nil)
+	languageSelect.SetSelected(autoLanguage)
+	if code := prefs.String("language"); code != "" {
+		languageSelect.SetSelected(whisper.LanguageName(code))
+	}
+	languageSelect.OnChanged = func(s string) {
+		prefs.SetString("language", languageCode(s))
+	}
+
+	// Translate produces English text whatever language is spoken
+	taskRadio := widget.NewRadioGroup([]string{taskTranscribe, taskTranslate}, nil)
+	taskRadio.Horizontal = true
+	taskRadio.Required = true
+	taskRadio.SetSelected(taskTranscribe)
+	if whisper.Task(prefs.String("task")) == whisper.TaskTranslate {
+		taskRadio.SetSelected(taskTranslate)
+	}
+	taskRadio.OnChanged = func(s string) {
+		prefs.SetString("task", string(selectedTask(s)))
+	}
+
 	// Create status label with binding
 	statusBinding := binding.NewString()
 	statusBinding.Set("Ready to record")
@@ -148,11 +236,49 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 		})
 	}
 
+	// Warn while recording if the input overflows and leaves gaps in the take
+	onDropped := func(dropped int) {
+		fyne.Do(func() {
+			if isRecording {
+				statusBinding.Set(fmt.Sprintf("⚠️ Recording... (%d frames dropped)", dropped))
+			}
+		})
+	}
+
+	// A fatal stream error stops the monitor, so blank the meter instead of leaving it frozen
+	onAudioError := func(err error) {
+		fyne.Do(func() {
+			statusBinding.Set("Error: audio input stopped: " + err.Error())
+			onLevel(0)
+		})
+	}
+
 	// Function to start or restart the monitor stream
 	startAudioMonitor := func() {
-		audio.StartMonitoring(selectedDevice, func() float64 { return volumeSlider.Value }, onLevel)
+		settings := loadDeviceSettings(prefs, selectedDevice)
+		audio.StartMonitoring(selectedDevice, settings, func() float64 { return volumeSlider.Value }, onLevel, onDropped, onAudioError)
 	}
 
+	// Advanced per-device settings, validated against the device before they are saved
+	audioSettingsBtn := widget.NewButton("⚙", func() {
+		if isRecording {
+			statusBinding.Set("Stop recording before changing audio settings")
+			return
+		}
+		current := loadDeviceSettings(prefs, selectedDevice)
+		// Some devices refuse format queries while they are already open, so the monitor
+		// is off for as long as the dialog is
+		audio.StopMonitoring()
+		onLevel(0)
+		showAudioSettings(w, selectedDevice, current, func(settings audio.DeviceSettings) error {
+			if err := audio.ValidateDeviceSettings(selectedDevice, settings); err != nil {
+				return err
+			}
+			saveDeviceSettings(prefs, selectedDevice, settings)
+			return nil
+		}, startAudioMonitor)
+	})
+
 	// Now set the OnChanged for deviceSelect since we have onLevel defined
 	deviceSelect.OnChanged = func(s string) {
 		selectedDevice = s
@@ -170,8 +296,9 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 		widget.NewLabel("Lvl:"),
 		container.NewCenter(vuMeter),
 	)
-	modelGroup := container.NewHBox(widget.NewLabel("Model:"), modelSelect)
-	inputGroup := container.NewHBox(widget.NewLabel("Input:"), deviceSelect)
+	modelGroup := container.NewHBox(widget.NewLabel("Engine:"), backendSelect, widget.NewLabel("Model:"), modelSelect, computeLabel, computeSelect)
+	languageGroup := container.NewHBox(widget.NewLabel("Language:"), languageSelect, taskRadio)
+	inputGroup := container.NewHBox(widget.NewLabel("Input:"), deviceSelect, audioSettingsBtn)
 	gpuGroup := container.NewHBox(gpuIndicator, gpuStatusLabel)
 	readyGroup := container.NewHBox(readyIndicator, readyStatusLabel)
 
@@ -180,6 +307,7 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 	statusBarRow1 := container.NewHBox(
 		statusGroup,
 		layout.NewSpacer(),
+		languageGroup,
 		volumeGroup,
 	)
 
@@ -204,104 +332,289 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 	textBox.Bind(bindStr)
 	textBox.SetPlaceHolder("Your transcribed text will appear here...\n\nClick 'Start Recording' to begin.")
 
+	// Markers flag important moments in long recordings and are shown inline in the transcript
+	addMarker := func() {
+		if n, ok := audio.AddMarker(); ok {
+			statusBinding.Set(fmt.Sprintf("📍 Marker %d added", n))
+		}
+	}
+	markBtn := widget.NewButton("📍 Mark", addMarker)
+	markBtn.Disable()
+	w.Canvas().AddShortcut(&desktop.CustomShortcut{KeyName: fyne.KeyM, Modifier: fyne.KeyModifierShortcutDefault}, func(fyne.Shortcut) {
+		addMarker()
+	})
+
+	// Transcription progress, shown only while a job is being transcribed
+	progressBar := widget.NewProgressBar()
+	progressBar.Hide()
+	progressStatus := func(job whisper.Job) string {
+		status := fmt.Sprintf("⏳ Transcribing %s... %.0f%%", job.Name, job.Progress*100)
+		if job.Progress > 0 && job.Progress < 1 {
+			elapsed := time.Since(job.Started)
+			remaining := time.Duration(float64(elapsed) * (1 - job.Progress) / job.Progress)
+			status += fmt.Sprintf(" (about %s left)", remaining.Round(time.Second))
+		}
+		return status
+	}
+
+	// Takes and imported files are transcribed in the background, one at a time, so a new
+	// recording can start while earlier ones are still being decoded.
+	runningJob := 0
+	var onJob func(whisper.Job)
+	queue := whisper.NewQueue(ctx, func(job whisper.Job) {
+		fyne.Do(func() { onJob(job) })
+	})
+	defer queue.Close()
+	jobsPanel := newQueuePanel(queue, w)
+	jobsPanel.box.Hide()
+
+	// Cancel abandons the transcription in progress; the model stays loaded
+	cancelBtn := widget.NewButton("✖ Cancel", func() {
+		if runningJob != 0 {
+			queue.Cancel(runningJob)
+			statusBinding.Set("⏳ Cancelling...")
+		}
+	})
+	cancelBtn.Hide()
+
 	var startStop *widget.Button
 
+	// Takes are numbered as they are started. A short take may be transcribed before a long
+	// one recorded earlier, so a finished take waits until every take before it is in;
+	// unshown lists the takes not yet in the transcript, in capture order.
+	type take struct {
+		rec      audio.RecordingInfo
+		report   audio.PreprocessReport
+		text     string
+		finished bool // transcribed, or given up on
+		shown    bool
+	}
+	takes := map[int]*take{}  // by take number
+	jobTakes := map[int]int{} // take number by job id
+	var unshown []int
+	nextTake := 0
+
+	appendText := func(text string) {
+		if text == "" {
+			return
+		}
+		current, _ := bindStr.Get()
+		if current != "" {
+			current += "\n"
+		}
+		bindStr.Set(current + text)
+	}
+	showTakes := func() {
+		for len(unshown) > 0 {
+			t := takes[unshown[0]]
+			if !t.finished {
+				return
+			}
+			t.shown = true
+			appendText(t.text)
+			unshown = unshown[1:]
+		}
+	}
+
+	onJob = func(job whisper.Job) {
+		jobsPanel.refresh()
+
+		switch job.Status {
+		case whisper.JobRunning:
+			runningJob = job.ID
+			progressBar.SetValue(job.Progress)
+			progressBar.Show()
+			cancelBtn.Show()
+		case whisper.JobDone:
+			if n, ok := jobTakes[job.ID]; ok && !takes[n].shown {
+				takes[n].text, takes[n].finished = job.Result.Text, true
+				showTakes()
+			} else {
+				// Imports, and takes retried after later ones were shown, go at the end
+				appendText(job.Result.Text)
+			}
+		case whisper.JobFailed, whisper.JobCancelled:
+			// Later takes do not wait for one that failed
+			if n, ok := jobTakes[job.ID]; ok && !takes[n].shown {
+				takes[n].finished = true
+				showTakes()
+			}
+		}
+		if job.Status.Finished() && job.ID == runningJob {
+			runningJob = 0
+			progressBar.Hide()
+			cancelBtn.Hide()
+		}
+
+		// A take being recorded owns the status line until it is stopped
+		if isRecording {
+			return
+		}
+		switch job.Status {
+		case whisper.JobRunning:
+			statusBinding.Set(progressStatus(job))
+			recordingIndicator.FillColor = color.RGBA{R: 255, G: 165, B: 0, A: 255} // Orange
+		case whisper.JobCancelled:
+			statusBinding.Set("✖ Transcription cancelled")
+			recordingIndicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+		case whisper.JobFailed:
+			statusBinding.Set("Error: " + job.Err.Error())
+			recordingIndicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+		case whisper.JobDone:
+			result := job.Result
+			done := "Transcription complete"
+			if result.Task == whisper.TaskTranslate {
+				done = "Translation complete"
+			}
+			status := "✓ " + done
+			var notes []string
+			if result.Language != "" {
+				language := whisper.LanguageName(result.Language)
+				if result.LanguageProbability > 0 {
+					language += fmt.Sprintf(" %.0f%%", result.LanguageProbability*100)
+				}
+				notes = append(notes, language)
+			}
+			if n, ok := jobTakes[job.ID]; ok {
+				t := takes[n]
+				if t.rec.DroppedFrames > 0 {
+					status = "⚠️ " + done
+					notes = append(notes, fmt.Sprintf("%d frames dropped", t.rec.DroppedFrames))
+				}
+				if report := t.report.String(); report != "" {
+					notes = append(notes, report)
+				}
+			}
+			if len(notes) > 0 {
+				status += " (" + strings.Join(notes, "; ") + ")"
+			}
+			statusBinding.Set(status)
+			recordingIndicator.FillColor = color.RGBA{R: 34, G: 139, B: 34, A: 255} // Green
+		default:
+			return
+		}
+		recordingIndicator.Refresh()
+	}
+
+	// newRequest builds a request for audioPath from the current settings
+	newRequest := func(audioPath string, markers []float64) whisper.Request {
+		return whisper.Request{
+			AudioFile:      audioPath,
+			Task:           selectedTask(taskRadio.Selected),
+			Language:       prefs.String("language"),
+			InitialPrompt:  activeInitialPrompt(prefs),
+			Decode:         loadDecodeOptions(prefs),
+			Markers:        markers,
+			WordTimestamps: prefs.Bool("word_timestamps"),
+		}
+	}
+
+	// Imported files wait behind live takes, which someone is waiting for
+	importAudio := func() {
+		showImportDialog(w, func(path string) {
+			if _, err := queue.Add(whisper.Job{
+				Name:     importJobName(path),
+				Request:  newRequest(path, nil),
+				Priority: whisper.PriorityLow,
+			}); err != nil {
+				statusBinding.Set("Error: " + err.Error())
+				return
+			}
+			statusBinding.Set("⏳ Queued " + filepath.Base(path))
+		})
+	}
+
+	// Capture is decoupled from transcription: stopping a take closes its file right away,
+	// so the next one can start while this one is cleaned up and queued in the background
+	var stopTake func()
 	startStop = widget.NewButton("Start Recording", func() {
 		if !isRecording {
+			// Use the OS temp directory; takes can follow each other within a second
+			audioPath := fmt.Sprintf("%s/%d.wav", os.TempDir(), time.Now().UnixNano())
+			if err := audio.StartRecording(audioPath); err != nil {
+				os.Remove(audioPath)
+				statusBinding.Set("Error starting recording: " + err.Error())
+				recordingIndicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255}
+				recordingIndicator.Refresh()
+				return
+			}
+
 			isRecording = true
+			markBtn.Enable()
 			startStop.SetText("⏹ Stop Recording")
 			startStop.Importance = widget.HighImportance
-			statusBinding.Set("🎤 Recording...")
+			if runningJob != 0 {
+				statusBinding.Set("🎤 Recording... (previous take still transcribing)")
+			} else {
+				statusBinding.Set("🎤 Recording...")
+			}
 			recordingIndicator.FillColor = color.RGBA{R: 220, G: 20, B: 60, A: 255} // Crimson red
 			recordingIndicator.Refresh()
 
-			go func() {
-				// Use the OS temp directory
-				audioPath := fmt.Sprintf("%s/%d.wav", os.TempDir(), time.Now().Unix())
-
-				// Ensure temp file is cleaned up even on crash
-				defer os.Remove(audioPath)
-				err := audio.StartRecording(audioPath)
-				if err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						fyne.Do(func() {
-							bindStr.Set("Error starting recording: " + err.Error())
-							isRecording = false
-							startStop.SetText("▶ Start Recording")
-							startStop.Importance = widget.MediumImportance
-							statusBinding.Set("Error: " + err.Error())
-							recordingIndicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255}
-							recordingIndicator.Refresh()
-						})
-						return
-					}
-				}
-
-				// Wait until user stops recording or window closes
-				for isRecording {
-					select {
-					case <-ctx.Done():
-						audio.StopRecording()
-						return
-					case <-time.After(200 * time.Millisecond):
-						// Continue waiting
-					}
-				}
-
-				audio.StopRecording()
+			// The take's place in the transcript is fixed now, whenever its text arrives
+			nextTake++
+			n := nextTake
+			takes[n] = &take{}
+			unshown = append(unshown, n)
+
+			stopped := make(chan audio.RecordingInfo, 1)
+			stopTake = func() {
+				recInfo, _ := audio.StopRecording()
+				stopped <- recInfo
+				startStop.SetText("▶ Start Recording")
+				startStop.Importance = widget.MediumImportance
+				statusBinding.Set("⏳ Processing...")
+			}
 
-				// Check if context is cancelled before UI updates
+			go func() {
+				var recInfo audio.RecordingInfo
 				select {
+				case recInfo = <-stopped:
 				case <-ctx.Done():
+					audio.StopRecording()
+					os.Remove(audioPath)
 					return
-				default:
-					fyne.Do(func() {
-						statusBinding.Set("⏳ Transcribing...")
-						recordingIndicator.FillColor = color.RGBA{R: 255, G: 165, B: 0, A: 255} // Orange
-						recordingIndicator.Refresh()
-					})
 				}
 
-				transcript, err := whisper.Transcribe(audioPath, useGPU)
-				if err != nil {
-					select {
-					case <-ctx.Done():
-						return
-					default:
-						fyne.Do(func() {
-							bindStr.Set("Transcription error: " + err.Error())
-							startStop.SetText("▶ Start Recording")
-							startStop.Importance = widget.MediumImportance
-							statusBinding.Set("Error: " + err.Error())
-							recordingIndicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255}
-							recordingIndicator.Refresh()
-						})
-						return
-					}
+				// Trim silent tails and even out loudness; on failure the untouched take is transcribed
+				var preReport audio.PreprocessReport
+				if processed, report, err := audio.Preprocess(recInfo, loadPreprocessOptions(prefs)); err == nil {
+					recInfo, preReport = processed, report
 				}
 
-				// Check if context is cancelled before final UI updates
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					fyne.Do(func() {
-						bindStr.Set(transcript)
-						startStop.SetText("▶ Start Recording")
-						startStop.Importance = widget.MediumImportance
-						statusBinding.Set("✓ Transcription complete")
-						recordingIndicator.FillColor = color.RGBA{R: 34, G: 139, B: 34, A: 255} // Green
-						recordingIndicator.Refresh()
+				// The queue deletes the file once the job is cleared or the window closes
+				fyne.Do(func() {
+					id, err := queue.Add(whisper.Job{
+						Name:      fmt.Sprintf("🎤 Take %d (%s)", n, time.Now().Format("15:04:05")),
+						Request:   newRequest(audioPath, recInfo.MarkerSeconds()),
+						Priority:  whisper.PriorityHigh,
+						Temporary: true,
 					})
-				}
+					if err != nil {
+						os.Remove(audioPath)
+						takes[n].finished = true
+						showTakes()
+						return
+					}
+					jobTakes[id] = n
+					takes[n].rec, takes[n].report = recInfo, preReport
+					if isRecording {
+						return
+					}
+					if runningJob == 0 {
+						statusBinding.Set("⏳ Transcribing...")
+					} else {
+						statusBinding.Set("⏳ Queued behind the take being transcribed")
+					}
+					recordingIndicator.FillColor = color.RGBA{R: 255, G: 165, B: 0, A: 255} // Orange
+					recordingIndicator.Refresh()
+				})
 			}()
 
 		} else {
 			isRecording = false
-			statusBinding.Set("⏳ Processing...")
+			markBtn.Disable()
+			stopTake()
 		}
 	})
 
@@ -324,10 +637,22 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 		recordingIndicator.Refresh()
 	})
 
+	queueBtn := widget.NewButton("☰ Queue", func() {
+		if jobsPanel.box.Visible() {
+			jobsPanel.box.Hide()
+		} else {
+			jobsPanel.refresh()
+			jobsPanel.box.Show()
+		}
+	})
+
 	// Button container with better layout
 	buttonBar := container.NewHBox(
 		layout.NewSpacer(),
 		startStop,
+		markBtn,
+		cancelBtn,
+		queueBtn,
 		copyBtn,
 		clearBtn,
 		layout.NewSpacer(),
@@ -342,13 +667,51 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 		),
 		container.NewVBox(
 			widget.NewSeparator(),
+			progressBar,
 			buttonBar,
 		),
 		nil,
-		nil,
+		jobsPanel.box,
 		container.NewPadded(textBox),
 	)
 
+	// Word timings cost extra decoding time, so they are opt-in
+	wordTimestampsItem := fyne.NewMenuItem("Word-level Timestamps", nil)
+	wordTimestampsItem.Checked = prefs.Bool("word_timestamps")
+
+	settingsMenu := fyne.NewMenu("Settings",
+		fyne.NewMenuItem("Pre-processing…", func() { showPreprocessSettings(w, prefs) }),
+		fyne.NewMenuItem("Vocabulary & Prompt…", func() { showVocabularySettings(w, prefs) }),
+		fyne.NewMenuItem("Advanced Decoding…", func() { showDecodeSettings(w, prefs) }),
+		fyne.NewMenuItemSeparator(),
+		wordTimestampsItem,
+	)
+	wordTimestampsItem.Action = func() {
+		wordTimestampsItem.Checked = !wordTimestampsItem.Checked
+		prefs.SetBool("word_timestamps", wordTimestampsItem.Checked)
+		settingsMenu.Refresh()
+	}
+	// Engines that cannot time words (whisper.cpp) would silently ignore the setting, so
+	// it is disabled while one is loaded; the preference is kept for the next engine
+	showWordTimestamps := func(backend string) {
+		caps, err := whisper.BackendCapabilities(backend)
+		wordTimestampsItem.Disabled = err != nil || !caps.WordTimestamps
+		wordTimestampsItem.Checked = !wordTimestampsItem.Disabled && prefs.Bool("word_timestamps")
+		settingsMenu.Refresh()
+	}
+	showWordTimestamps(selectedBackend)
+
+	helpMenu := fyne.NewMenu("Help",
+		fyne.NewMenuItem("About / Diagnostics…", func() {
+			showDiagnostics(w, systemInfo{gpuName: gpuName, vramGB: vramGB, ramGB: ramGB}, deviceSelect.Selected)
+		}),
+	)
+
+	fileMenu := fyne.NewMenu("File",
+		fyne.NewMenuItem("Import Audio…", importAudio),
+	)
+
+	w.SetMainMenu(fyne.NewMainMenu(fileMenu, settingsMenu, helpMenu))
 	w.SetContent(content)
 
 	// Show GPU status dialog at startup
@@ -365,83 +728,170 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 		)
 	}
 
+	// The worker is restarted if it dies; mirror that on the Backend LED. readyText is the
+	// label for a loaded backend, cleared while loadModel runs so it is not shown too early.
+	orangeColor := color.RGBA{R: 255, G: 165, B: 0, A: 255}
+	readyText := ""
+	whisper.OnWorkerState(func(state whisper.WorkerState, reason string) {
+		fyne.Do(func() {
+			switch state {
+			case whisper.WorkerRestarting:
+				readyStatusLabel.SetText("Backend: Restarting (" + shorten(reason, 60) + ")")
+				readyLed.FillColor = orangeColor
+				statusBinding.Set("⚠️ Backend stopped (" + reason + "), restarting...")
+			case whisper.WorkerFailed:
+				readyStatusLabel.SetText("Backend: Failed (" + shorten(reason, 60) + ")")
+				readyLed.FillColor = redColor
+				statusBinding.Set("Error: backend could not be restarted; select a model to load it again")
+			case whisper.WorkerReady:
+				if readyText == "" {
+					return
+				}
+				readyStatusLabel.SetText(readyText)
+				readyLed.FillColor = greenColor
+			default:
+				return
+			}
+			readyLed.Refresh()
+		})
+	})
+
 	startStop.Disable() // Disable start button while loading model
+	backendSelect.Disable()
 	modelSelect.Disable()
+	computeSelect.Disable()
+
+	var loadModel func(backend string, modelName string, computeType string, gpuMode bool)
+	loadModel = func(backend string, modelName string, computeType string, gpuMode bool) {
+		// Only ask for the GPU if the backend can use it
+		if caps, err := whisper.BackendCapabilities(backend); err == nil && !caps.GPU {
+			gpuMode = false
+		}
+
+		// The loaded model keeps serving while the new one loads, if both fit in memory
+		memory := ramGB
+		if gpuMode {
+			memory = vramGB
+		}
+		_, loadedErr := whisper.Info()
+		hotSwap := loadedErr == nil &&
+			modelMemoryGB(lastWorkingBackend, lastWorkingModel)+modelMemoryGB(backend, modelName) <= memory*memoryHeadroom
 
-	var loadModel func(modelName string, gpuMode bool)
-	loadModel = func(modelName string, gpuMode bool) {
 		fyne.Do(func() {
-			readyStatusLabel.SetText("Backend: Loading...")
-			readyLed.FillColor = color.RGBA{R: 255, G: 165, B: 0, A: 255} // Orange
-			readyLed.Refresh()
-			startStop.Disable()
+			if hotSwap {
+				readyStatusLabel.SetText(fmt.Sprintf("Backend: Loading %s (%s still in use)...", modelName, lastWorkingModel))
+			} else {
+				readyText = ""
+				readyStatusLabel.SetText("Backend: Loading...")
+				readyLed.FillColor = orangeColor
+				readyLed.Refresh()
+				startStop.Disable()
+			}
+			backendSelect.Disable()
 			modelSelect.Disable()
+			computeSelect.Disable()
 		})
 
-		err := whisper.Init(gpuMode, modelName)
+		err := whisper.Init(backend, whisper.LoadOptions{Model: modelName, UseGPU: gpuMode, ComputeType: computeType, Exclusive: !hotSwap})
 		select {
 		case <-ctx.Done():
 			return
 		default:
 			fyne.Do(func() {
-				if err != nil {
+				if err != nil && hotSwap {
+					// The previous model was never unloaded, so there is nothing to fall back to
+					showBackend(lastWorkingBackend, lastWorkingModel, lastWorkingComputeType)
+					readyStatusLabel.SetText(readyText)
+					statusBinding.Set(fmt.Sprintf("Error loading model '%s' (%s): %v; still using '%s'", modelName, backend, err, lastWorkingModel))
+					backendSelect.Enable()
+					modelSelect.Enable()
+					computeSelect.Enable()
+				} else if err != nil && backend == lastWorkingBackend && modelName == lastWorkingModel && computeType == lastWorkingComputeType {
+					// The last working model is what failed, so retrying would only fail again
+					readyText = ""
+					readyStatusLabel.SetText("Backend: Error")
+					readyLed.FillColor = redColor
+					readyLed.Refresh()
+					bindStr.Set(fmt.Sprintf("Error loading model '%s' (%s): %v\nChoose another engine or model.", modelName, backend, err))
+					statusBinding.Set("Error loading model")
+					recordingIndicator.FillColor = redColor
+					recordingIndicator.Refresh()
+					backendSelect.Enable()
+					modelSelect.Enable()
+					computeSelect.Enable()
+				} else if err != nil {
 					// Fall back to the last working model if initialization failed
 					readyStatusLabel.SetText("Backend: Error")
 					readyLed.FillColor = redColor
 					readyLed.Refresh()
-					bindStr.Set(fmt.Sprintf("Error loading model '%s': %v\nFalling back to '%s'...", modelName, err, lastWorkingModel))
+					bindStr.Set(fmt.Sprintf("Error loading model '%s' (%s): %v\nFalling back to '%s' (%s)...", modelName, backend, err, lastWorkingModel, lastWorkingBackend))
 					statusBinding.Set("Error loading model")
 					recordingIndicator.FillColor = redColor
 					recordingIndicator.Refresh()
 
-					// Revert the dropdown visually without triggering OnChanged
-					modelSelect.SetSelected(lastWorkingModel)
+					// Revert the dropdowns visually without triggering OnChanged
+					showBackend(lastWorkingBackend, lastWorkingModel, lastWorkingComputeType)
 
 					// Trigger background reload of the last working model
 					// Use the global useGPU state for our safe fallback
-					go loadModel(lastWorkingModel, useGPU)
+					go loadModel(lastWorkingBackend, lastWorkingModel, lastWorkingComputeType, useGPU)
 				} else {
 					// Success! Update our fallback state
-					lastWorkingModel = modelName
+					lastWorkingBackend, lastWorkingModel, lastWorkingComputeType = backend, modelName, computeType
+					showWordTimestamps(backend)
+					prefs.SetString("backend", backend)
+					if computeType != "" {
+						prefs.SetString("compute_type."+backend, computeType)
+					}
 
 					startStop.Enable()
 					modeStr := "CPU"
 					if gpuMode {
 						modeStr = "GPU"
 					}
-					readyStatusLabel.SetText(fmt.Sprintf("Backend: Ready (%s, %s)", modelName, modeStr))
+					if computeType != "" {
+						modeStr += " " + computeType
+					}
+					readyText = fmt.Sprintf("Backend: Ready (%s %s, %s)", backend, modelName, modeStr)
+					readyStatusLabel.SetText(readyText)
 					readyLed.FillColor = greenColor
 					readyLed.Refresh()
 					statusBinding.Set("Ready to record")
 					recordingIndicator.FillColor = greenColor
 					recordingIndicator.Refresh()
+					backendSelect.Enable()
 					modelSelect.Enable()
+					computeSelect.Enable()
 				}
 			})
 		}
 	}
 
+	backendSelect.OnChanged = func(s string) {
+		if s != selectedBackend && s != "" {
+			caps, err := whisper.BackendCapabilities(s)
+			if err != nil {
+				return
+			}
+			showBackend(s, caps.DefaultModel, preferredComputeType(s))
+			go loadModel(selectedBackend, selectedModel, selectedComputeType, useGPU)
+		}
+	}
+
+	computeSelect.OnChanged = func(s string) {
+		if s != selectedComputeType && s != "" {
+			selectedComputeType = s
+			go loadModel(selectedBackend, selectedModel, selectedComputeType, useGPU)
+		}
+	}
+
 	modelSelect.OnChanged = func(s string) {
 		// Only trigger reload if the value actually changed
 		if s != selectedModel && s != "" {
 			selectedModel = s
 
 			// Always prompt the user with system requirements when switching models
-			var vramReq float64
-			switch s {
-			case "tiny":
-				vramReq = 1.0
-			case "base":
-				vramReq = 1.0
-			case "small":
-				vramReq = 2.0
-			case "medium":
-				vramReq = 5.0
-			case "large":
-				vramReq = 10.5
-			default:
-				vramReq = 2.0
-			}
+			vramReq := modelMemoryGB(selectedBackend, s)
 
 			// Format the resource usage string
 			var resourceStats string
@@ -462,11 +912,10 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 				func(proceed bool) {
 					if proceed {
 						// Attempt to load the model (may crash)
-						go loadModel(selectedModel, useGPU)
+						go loadModel(selectedBackend, selectedModel, selectedComputeType, useGPU)
 					} else {
 						// Revert dropdown nicely
-						modelSelect.SetSelected(lastWorkingModel)
-						selectedModel = lastWorkingModel
+						showBackend(lastWorkingBackend, lastWorkingModel, lastWorkingComputeType)
 					}
 				},
 				w,
@@ -477,8 +926,43 @@ func Run(useGPU bool, gpuName string, vramGB float64, ramGB float64) {
 	// Delay the initial load slightly so Fyne has time to start its event loop
 	go func() {
 		time.Sleep(100 * time.Millisecond)
-		loadModel(selectedModel, useGPU)
+		loadModel(selectedBackend, selectedModel, selectedComputeType, useGPU)
 	}()
 
 	w.ShowAndRun()
 }
+
+// defaultBackend is the engine used until the user picks another: local, so recordings
+// stay on the machine.
+const defaultBackend = "openai-whisper"
+
+// memoryHeadroom is the share of memory two models may take together to be loaded side by side
+const memoryHeadroom = 0.8
+
+// modelMemoryGB estimates the RAM or VRAM a model takes once loaded. Remote servers take none here.
+func modelMemoryGB(backend, model string) float64 {
+	if backend == "openai-api" {
+		return 0
+	}
+	switch {
+	case strings.Contains(model, "tiny"), strings.Contains(model, "base"):
+		return 1.0
+	case strings.Contains(model, "small"):
+		return 2.0
+	case strings.Contains(model, "medium"):
+		return 5.0
+	case strings.Contains(model, "large"):
+		return 10.5
+	default:
+		return 2.0
+	}
+}
+
+// shorten cuts s to at most n runes, marking the cut with an ellipsis
+func shorten(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,78 @@
+package ui
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"whispergui/whisper"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"github.com/atotto/clipboard"
+)
+
+// systemInfo is what the app knows about the machine, gathered once at startup
+type systemInfo struct {
+	gpuName string
+	vramGB  float64
+	ramGB   float64
+}
+
+// diagnosticsReport describes the running setup as plain text, suitable for pasting into a bug report
+func diagnosticsReport(sys systemInfo, inputDevice string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "App: Whisper Voice-to-Text (%s, %s/%s)\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+
+	gpuName := sys.gpuName
+	if gpuName == "" {
+		gpuName = "none"
+	}
+	fmt.Fprintf(&b, "GPU: %s (%.1f GB VRAM)\n", gpuName, sys.vramGB)
+	fmt.Fprintf(&b, "RAM: %.1f GB available\n", sys.ramGB)
+	fmt.Fprintf(&b, "Input: %s\n\n", inputDevice)
+
+	info, err := whisper.Info()
+	if err != nil {
+		fmt.Fprintf(&b, "Backend: not loaded (%v)\n", err)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Backend: %s\n", info.Backend)
+	fmt.Fprintf(&b, "Model: %s\n", info.Model)
+	fmt.Fprintf(&b, "Device: %s\n", info.Device)
+	if info.DType != "" {
+		fmt.Fprintf(&b, "Precision: %s\n", info.DType)
+	}
+	if info.Protocol != 0 {
+		fmt.Fprintf(&b, "Worker protocol: %d\n", info.Protocol)
+	}
+	if len(info.Options) > 0 {
+		fmt.Fprintf(&b, "Options: %s\n", strings.Join(info.Options, ", "))
+	}
+
+	components := make([]string, 0, len(info.Versions))
+	for name := range info.Versions {
+		components = append(components, name)
+	}
+	slices.Sort(components)
+	for _, name := range components {
+		fmt.Fprintf(&b, "%s: %s\n", name, info.Versions[name])
+	}
+	return b.String()
+}
+
+func showDiagnostics(w fyne.Window, sys systemInfo, inputDevice string) {
+	report := diagnosticsReport(sys, inputDevice)
+
+	text := widget.NewLabel(report)
+	text.TextStyle = fyne.TextStyle{Monospace: true}
+
+	d := dialog.NewCustomConfirm("About / Diagnostics", "Copy", "Close", text, func(copyReport bool) {
+		if copyReport {
+			_ = clipboard.WriteAll(report)
+		}
+	}, w)
+	d.Show()
+}
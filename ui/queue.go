@@ -0,0 +1,144 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"time"
+
+	"whispergui/whisper"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Extensions offered when importing audio; every engine decodes anything ffmpeg reads
+var importExtensions = []string{".wav", ".mp3", ".m4a", ".flac", ".ogg", ".opus", ".webm", ".mp4"}
+
+// queuePanel lists the jobs of a whisper.Queue with controls to cancel, retry and remove them
+type queuePanel struct {
+	queue *whisper.Queue
+	jobs  []whisper.Job
+	list  *widget.List
+	title *widget.Label
+	box   *fyne.Container
+}
+
+func newQueuePanel(q *whisper.Queue, w fyne.Window) *queuePanel {
+	p := &queuePanel{queue: q, title: widget.NewLabel("Queue")}
+	p.title.TextStyle = fyne.TextStyle{Bold: true}
+
+	p.list = widget.NewList(
+		func() int { return len(p.jobs) },
+		func() fyne.CanvasObject {
+			name := widget.NewLabel("")
+			name.Truncation = fyne.TextTruncateEllipsis
+			return container.NewBorder(nil, nil, nil,
+				container.NewHBox(widget.NewButton("Retry", nil), widget.NewButton("✖", nil)),
+				container.NewVBox(name, widget.NewLabel("")),
+			)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			job := p.jobs[i]
+			row := o.(*fyne.Container)
+			labels := row.Objects[0].(*fyne.Container).Objects
+			buttons := row.Objects[1].(*fyne.Container).Objects
+			labels[0].(*widget.Label).SetText(job.Name)
+			labels[1].(*widget.Label).SetText(jobStatusText(job))
+
+			retry := buttons[0].(*widget.Button)
+			retry.OnTapped = func() {
+				if err := q.Retry(job.ID); err != nil {
+					dialog.ShowError(err, w)
+				}
+			}
+			if job.Status == whisper.JobFailed || job.Status == whisper.JobCancelled {
+				retry.Show()
+			} else {
+				retry.Hide()
+			}
+
+			// Unfinished jobs are cancelled, finished ones removed
+			remove := buttons[1].(*widget.Button)
+			remove.OnTapped = func() {
+				if !job.Status.Finished() {
+					q.Cancel(job.ID)
+					return
+				}
+				if err := q.Remove(job.ID); err != nil {
+					dialog.ShowError(err, w)
+				}
+				p.refresh()
+			}
+		},
+	)
+
+	clearBtn := widget.NewButton("Clear Done", func() {
+		q.ClearFinished()
+		p.refresh()
+	})
+	// The list has no width of its own; keep names readable
+	width := canvas.NewRectangle(color.Transparent)
+	width.SetMinSize(fyne.NewSize(280, 0))
+	p.box = container.NewStack(width, container.NewBorder(
+		container.NewHBox(p.title, layout.NewSpacer(), clearBtn),
+		nil, nil, nil,
+		p.list,
+	))
+	return p
+}
+
+// refresh reloads the jobs from the queue; it must run on the UI goroutine.
+func (p *queuePanel) refresh() {
+	p.jobs = p.queue.Jobs()
+	active := 0
+	for _, job := range p.jobs {
+		if !job.Status.Finished() {
+			active++
+		}
+	}
+	p.title.SetText(fmt.Sprintf("Queue (%d active)", active))
+	p.list.Refresh()
+}
+
+func jobStatusText(job whisper.Job) string {
+	switch job.Status {
+	case whisper.JobPending:
+		return "⏸ Pending"
+	case whisper.JobRunning:
+		return fmt.Sprintf("⏳ Transcribing %.0f%%", job.Progress*100)
+	case whisper.JobDone:
+		return fmt.Sprintf("✓ Done in %s", job.Finished.Sub(job.Started).Round(100*time.Millisecond))
+	case whisper.JobFailed:
+		return "✖ " + job.Err.Error()
+	default:
+		return "✖ Cancelled"
+	}
+}
+
+// showImportDialog asks for an audio file and calls onFile with its path
+func showImportDialog(w fyne.Window, onFile func(path string)) {
+	d := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		if reader == nil {
+			return
+		}
+		reader.Close()
+		onFile(reader.URI().Path())
+	}, w)
+	d.SetFilter(storage.NewExtensionFileFilter(importExtensions))
+	d.Show()
+}
+
+// importJobName names a queue entry after the imported file
+func importJobName(path string) string {
+	return "📂 " + filepath.Base(path)
+}
@@ -0,0 +1,528 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"whispergui/audio"
+	"whispergui/whisper"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	defaultOption = "Device default"
+	latencyLow    = "Low"
+	latencyHigh   = "High"
+	formatInt16   = "16-bit PCM"
+	formatFloat32 = "32-bit float"
+)
+
+var bufferSizes = []int{256, 512, 1024, 2048, 4096}
+
+const (
+	autoLanguage   = "Auto-detect"
+	taskTranscribe = "Transcribe"
+	taskTranslate  = "Translate"
+)
+
+// selectedTask maps the task toggle to the task sent to the backend
+func selectedTask(label string) whisper.Task {
+	if label == taskTranslate {
+		return whisper.TaskTranslate
+	}
+	return whisper.TaskTranscribe
+}
+
+// languageCode maps a language dropdown entry to the code sent to the backend; auto-detect is empty
+func languageCode(name string) string {
+	for _, l := range whisper.Languages {
+		if l.Name == name {
+			return l.Code
+		}
+	}
+	return ""
+}
+
+// Preference keys are namespaced by device name so each input keeps its own settings
+func deviceSettingsKey(device string) string {
+	return "audio.device." + device
+}
+
+func loadDeviceSettings(prefs fyne.Preferences, device string) audio.DeviceSettings {
+	var settings audio.DeviceSettings
+	if raw := prefs.String(deviceSettingsKey(device)); raw != "" {
+		json.Unmarshal([]byte(raw), &settings)
+	}
+	return settings
+}
+
+func saveDeviceSettings(prefs fyne.Preferences, device string, settings audio.DeviceSettings) {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return
+	}
+	prefs.SetString(deviceSettingsKey(device), string(raw))
+}
+
+// showAudioSettings opens the advanced audio panel for device. onApply is called with the
+// chosen settings and may reject them, in which case the error is shown and nothing is saved.
+// onClose is called when the dialog closes, applied or not. The device is probed for its
+// sample rates, which some devices refuse while they are open, so the caller should stop
+// using it until then.
+func showAudioSettings(w fyne.Window, device string, current audio.DeviceSettings, onApply func(audio.DeviceSettings) error, onClose func()) {
+	rateOptions := []string{defaultOption}
+	rates, err := audio.SupportedSampleRates(device)
+	if err == nil {
+		for _, r := range rates {
+			rateOptions = append(rateOptions, fmt.Sprintf("%.0f Hz", r))
+		}
+	}
+	rateSelect := widget.NewSelect(rateOptions, nil)
+	rateSelect.SetSelectedIndex(0)
+	for i, r := range rates {
+		if r == current.SampleRate {
+			rateSelect.SetSelectedIndex(i + 1)
+		}
+	}
+
+	bufferOptions := []string{fmt.Sprintf("%s (%d)", defaultOption, audio.DefaultFramesPerBuffer)}
+	for _, b := range bufferSizes {
+		bufferOptions = append(bufferOptions, strconv.Itoa(b))
+	}
+	bufferSelect := widget.NewSelect(bufferOptions, nil)
+	bufferSelect.SetSelectedIndex(0)
+	for i, b := range bufferSizes {
+		if b == current.FramesPerBuffer {
+			bufferSelect.SetSelectedIndex(i + 1)
+		}
+	}
+
+	latencySelect := widget.NewSelect([]string{latencyLow, latencyHigh}, nil)
+	latencySelect.SetSelected(latencyLow)
+	if current.Latency == audio.LatencyHigh {
+		latencySelect.SetSelected(latencyHigh)
+	}
+
+	formatSelect := widget.NewSelect([]string{formatInt16, formatFloat32}, nil)
+	formatSelect.SetSelected(formatInt16)
+	if current.Format == audio.FormatFloat32 {
+		formatSelect.SetSelected(formatFloat32)
+	}
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Sample Rate", rateSelect),
+		widget.NewFormItem("Buffer Size", bufferSelect),
+		widget.NewFormItem("Latency", latencySelect),
+		widget.NewFormItem("Recording Format", formatSelect),
+	}
+
+	title := "Audio Settings"
+	if device != "" {
+		title = "Audio Settings: " + device
+	}
+
+	dialog.ShowForm(title, "Apply", "Cancel", items, func(apply bool) {
+		defer onClose()
+		if !apply {
+			return
+		}
+
+		var settings audio.DeviceSettings
+		if i := rateSelect.SelectedIndex(); i > 0 {
+			settings.SampleRate = rates[i-1]
+		}
+		if i := bufferSelect.SelectedIndex(); i > 0 {
+			settings.FramesPerBuffer = bufferSizes[i-1]
+		}
+		if latencySelect.Selected == latencyHigh {
+			settings.Latency = audio.LatencyHigh
+		} else {
+			settings.Latency = audio.LatencyLow
+		}
+		if formatSelect.Selected == formatFloat32 {
+			settings.Format = audio.FormatFloat32
+		} else {
+			settings.Format = audio.FormatInt16
+		}
+
+		if err := onApply(settings); err != nil {
+			dialog.ShowError(fmt.Errorf("the device rejected these settings: %v", err), w)
+		}
+	}, w)
+}
+
+const preprocessKey = "audio.preprocess"
+
+func loadPreprocessOptions(prefs fyne.Preferences) audio.PreprocessOptions {
+	opts := audio.DefaultPreprocessOptions()
+	if raw := prefs.String(preprocessKey); raw != "" {
+		json.Unmarshal([]byte(raw), &opts)
+	}
+	return opts
+}
+
+func savePreprocessOptions(prefs fyne.Preferences, opts audio.PreprocessOptions) {
+	raw, err := json.Marshal(opts)
+	if err != nil {
+		return
+	}
+	prefs.SetString(preprocessKey, string(raw))
+}
+
+// showPreprocessSettings opens the panel for the cleanup applied between recording and transcription.
+func showPreprocessSettings(w fyne.Window, prefs fyne.Preferences) {
+	current := loadPreprocessOptions(prefs)
+
+	trimCheck := widget.NewCheck("Trim leading/trailing silence", nil)
+	trimCheck.SetChecked(current.TrimSilence)
+	thresholdEntry := newFloatEntry(current.SilenceDB)
+	paddingEntry := newFloatEntry(current.PaddingSeconds)
+
+	normalizeCheck := widget.NewCheck("Normalize loudness", nil)
+	normalizeCheck.SetChecked(current.Normalize)
+	targetEntry := newFloatEntry(current.TargetLUFS)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("", trimCheck),
+		widget.NewFormItem("Silence Threshold (dBFS)", thresholdEntry),
+		widget.NewFormItem("Padding (s)", paddingEntry),
+		widget.NewFormItem("", normalizeCheck),
+		widget.NewFormItem("Target Loudness (LUFS)", targetEntry),
+	}
+
+	dialog.ShowForm("Pre-processing", "Save", "Cancel", items, func(save bool) {
+		if !save {
+			return
+		}
+		opts := audio.PreprocessOptions{
+			TrimSilence: trimCheck.Checked,
+			Normalize:   normalizeCheck.Checked,
+		}
+		opts.SilenceDB, _ = strconv.ParseFloat(thresholdEntry.Text, 64)
+		opts.PaddingSeconds, _ = strconv.ParseFloat(paddingEntry.Text, 64)
+		opts.TargetLUFS, _ = strconv.ParseFloat(targetEntry.Text, 64)
+		savePreprocessOptions(prefs, opts)
+	}, w)
+}
+
+// newFloatEntry returns an entry prefilled with v that only accepts numbers
+func newFloatEntry(v float64) *widget.Entry {
+	e := widget.NewEntry()
+	e.SetText(strconv.FormatFloat(v, 'f', -1, 64))
+	e.Validator = func(s string) error {
+		_, err := strconv.ParseFloat(s, 64)
+		return err
+	}
+	return e
+}
+
+const (
+	vocabularyKey       = "vocabulary.profiles"
+	activeVocabularyKey = "vocabulary.active"
+)
+
+// vocabularyProfile is a named initial prompt, so different domains can keep their own jargon
+type vocabularyProfile struct {
+	Name   string `json:"name"`
+	Prompt string `json:"prompt"`
+}
+
+func loadVocabularyProfiles(prefs fyne.Preferences) []vocabularyProfile {
+	var profiles []vocabularyProfile
+	if raw := prefs.String(vocabularyKey); raw != "" {
+		json.Unmarshal([]byte(raw), &profiles)
+	}
+	if len(profiles) == 0 {
+		profiles = []vocabularyProfile{{Name: "Default"}}
+	}
+	return profiles
+}
+
+func saveVocabularyProfiles(prefs fyne.Preferences, profiles []vocabularyProfile) {
+	raw, err := json.Marshal(profiles)
+	if err != nil {
+		return
+	}
+	prefs.SetString(vocabularyKey, string(raw))
+}
+
+// activeInitialPrompt returns the prompt of the selected vocabulary profile
+func activeInitialPrompt(prefs fyne.Preferences) string {
+	active := prefs.String(activeVocabularyKey)
+	for _, p := range loadVocabularyProfiles(prefs) {
+		if p.Name == active {
+			return p.Prompt
+		}
+	}
+	return ""
+}
+
+// showVocabularySettings opens the editor for the vocabulary profiles. The profile selected
+// when saving becomes the active one.
+func showVocabularySettings(w fyne.Window, prefs fyne.Preferences) {
+	profiles := loadVocabularyProfiles(prefs)
+	current := 0
+	for i, p := range profiles {
+		if p.Name == prefs.String(activeVocabularyKey) {
+			current = i
+		}
+	}
+
+	promptEntry := widget.NewMultiLineEntry()
+	promptEntry.Wrapping = fyne.TextWrapWord
+	promptEntry.SetMinRowsVisible(6)
+	promptEntry.SetPlaceHolder("e.g. Fyne, PortAudio, CUDA, whisper.cpp, LUFS")
+	promptEntry.SetText(profiles[current].Prompt)
+
+	profileNames := func() []string {
+		var names []string
+		for _, p := range profiles {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	// Switching profiles keeps the edits made to the previous one until the dialog is saved
+	profileSelect := widget.NewSelect(profileNames(), nil)
+	profileSelect.SetSelectedIndex(current)
+	profileSelect.OnChanged = func(string) {
+		i := profileSelect.SelectedIndex()
+		if i < 0 || i == current {
+			return
+		}
+		profiles[current].Prompt = promptEntry.Text
+		current = i
+		promptEntry.SetText(profiles[current].Prompt)
+	}
+
+	newBtn := widget.NewButton("New…", func() {
+		nameEntry := widget.NewEntry()
+		nameEntry.Validator = func(s string) error {
+			if s == "" {
+				return fmt.Errorf("name is required")
+			}
+			for _, p := range profiles {
+				if p.Name == s {
+					return fmt.Errorf("profile %q already exists", s)
+				}
+			}
+			return nil
+		}
+		dialog.ShowForm("New Profile", "Add", "Cancel", []*widget.FormItem{widget.NewFormItem("Name", nameEntry)}, func(add bool) {
+			if !add {
+				return
+			}
+			profiles = append(profiles, vocabularyProfile{Name: nameEntry.Text})
+			profileSelect.SetOptions(profileNames())
+			profileSelect.SetSelectedIndex(len(profiles) - 1)
+		}, w)
+	})
+
+	deleteBtn := widget.NewButton("Delete", func() {
+		if len(profiles) == 1 {
+			promptEntry.SetText("")
+			return
+		}
+		profiles = append(profiles[:current], profiles[current+1:]...)
+		current = 0
+		profileSelect.SetOptions(profileNames())
+		profileSelect.SetSelectedIndex(0)
+		promptEntry.SetText(profiles[0].Prompt)
+	})
+
+	hint := widget.NewLabel("Product names, acronyms and other terms Whisper should spell your way.\nThe text is given to the model as if it preceded the recording; only the\nlast ~200 words are used.")
+	content := container.NewBorder(
+		container.NewVBox(container.NewBorder(nil, nil, widget.NewLabel("Profile:"), container.NewHBox(newBtn, deleteBtn), profileSelect), hint),
+		nil, nil, nil,
+		promptEntry,
+	)
+
+	d := dialog.NewCustomConfirm("Vocabulary & Prompt", "Save", "Cancel", content, func(save bool) {
+		if !save {
+			return
+		}
+		profiles[current].Prompt = promptEntry.Text
+		saveVocabularyProfiles(prefs, profiles)
+		prefs.SetString(activeVocabularyKey, profiles[current].Name)
+	}, w)
+	d.Resize(fyne.NewSize(500, 380))
+	d.Show()
+}
+
+const decodeKey = "decode.options"
+
+const (
+	presetDefault  = "Backend default"
+	presetFast     = "Fast"
+	presetAccurate = "Accurate"
+	presetCustom   = "Custom"
+)
+
+// loadDecodeOptions returns the saved decoding options, or nil to leave them to the backend
+func loadDecodeOptions(prefs fyne.Preferences) *whisper.DecodeOptions {
+	raw := prefs.String(decodeKey)
+	if raw == "" {
+		return nil
+	}
+	var opts whisper.DecodeOptions
+	if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+		return nil
+	}
+	return &opts
+}
+
+func saveDecodeOptions(prefs fyne.Preferences, opts *whisper.DecodeOptions) {
+	if opts == nil {
+		prefs.SetString(decodeKey, "")
+		return
+	}
+	raw, err := json.Marshal(opts)
+	if err != nil {
+		return
+	}
+	prefs.SetString(decodeKey, string(raw))
+}
+
+// showDecodeSettings opens the advanced decoding panel. Picking a preset fills in the
+// fields; editing a field afterwards switches to Custom.
+func showDecodeSettings(w fyne.Window, prefs fyne.Preferences) {
+	current := loadDecodeOptions(prefs)
+	shown := whisper.AccurateDecodeOptions()
+	if current != nil {
+		shown = *current
+	}
+
+	beamEntry := newIntEntry(shown.BeamSize)
+	bestOfEntry := newIntEntry(shown.BestOf)
+	temperatureEntry := widget.NewEntry()
+	temperatureEntry.SetText(formatFloats(shown.Temperature))
+	temperatureEntry.Validator = func(s string) error {
+		_, err := parseFloats(s)
+		return err
+	}
+	fp16Check := widget.NewCheck("Half precision (fp16)", nil)
+	fp16Check.SetChecked(shown.FP16)
+	conditionCheck := widget.NewCheck("Condition on previous text", nil)
+	conditionCheck.SetChecked(shown.ConditionOnPreviousText)
+	compressionEntry := newFloatEntry(shown.CompressionRatioThreshold)
+	noSpeechEntry := newFloatEntry(shown.NoSpeechThreshold)
+
+	presetSelect := widget.NewSelect([]string{presetDefault, presetFast, presetAccurate, presetCustom}, nil)
+	switch {
+	case current == nil:
+		presetSelect.SetSelected(presetDefault)
+	case reflect.DeepEqual(*current, whisper.FastDecodeOptions()):
+		presetSelect.SetSelected(presetFast)
+	case reflect.DeepEqual(*current, whisper.AccurateDecodeOptions()):
+		presetSelect.SetSelected(presetAccurate)
+	default:
+		presetSelect.SetSelected(presetCustom)
+	}
+
+	// Filling in a preset fires the fields' OnChanged, which must not flip the preset to Custom
+	applyingPreset := false
+	presetSelect.OnChanged = func(s string) {
+		var opts whisper.DecodeOptions
+		switch s {
+		case presetFast:
+			opts = whisper.FastDecodeOptions()
+		case presetAccurate:
+			opts = whisper.AccurateDecodeOptions()
+		default:
+			return
+		}
+		applyingPreset = true
+		beamEntry.SetText(strconv.Itoa(opts.BeamSize))
+		bestOfEntry.SetText(strconv.Itoa(opts.BestOf))
+		temperatureEntry.SetText(formatFloats(opts.Temperature))
+		fp16Check.SetChecked(opts.FP16)
+		conditionCheck.SetChecked(opts.ConditionOnPreviousText)
+		compressionEntry.SetText(strconv.FormatFloat(opts.CompressionRatioThreshold, 'f', -1, 64))
+		noSpeechEntry.SetText(strconv.FormatFloat(opts.NoSpeechThreshold, 'f', -1, 64))
+		applyingPreset = false
+	}
+	toCustom := func() {
+		if !applyingPreset {
+			presetSelect.SetSelected(presetCustom)
+		}
+	}
+	for _, e := range []*widget.Entry{beamEntry, bestOfEntry, temperatureEntry, compressionEntry, noSpeechEntry} {
+		e.OnChanged = func(string) { toCustom() }
+	}
+	fp16Check.OnChanged = func(bool) { toCustom() }
+	conditionCheck.OnChanged = func(bool) { toCustom() }
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Preset", presetSelect),
+		widget.NewFormItem("Beam Size", beamEntry),
+		widget.NewFormItem("Best Of", bestOfEntry),
+		widget.NewFormItem("Temperatures", temperatureEntry),
+		widget.NewFormItem("", fp16Check),
+		widget.NewFormItem("", conditionCheck),
+		widget.NewFormItem("Compression Ratio Threshold", compressionEntry),
+		widget.NewFormItem("No-Speech Threshold", noSpeechEntry),
+	}
+	items[3].HintText = "Fallback schedule, e.g. 0, 0.2, 0.4"
+
+	dialog.ShowForm("Advanced Decoding", "Save", "Cancel", items, func(save bool) {
+		if !save {
+			return
+		}
+		if presetSelect.Selected == presetDefault {
+			saveDecodeOptions(prefs, nil)
+			return
+		}
+		opts := whisper.DecodeOptions{
+			FP16:                    fp16Check.Checked,
+			ConditionOnPreviousText: conditionCheck.Checked,
+		}
+		opts.BeamSize, _ = strconv.Atoi(beamEntry.Text)
+		opts.BestOf, _ = strconv.Atoi(bestOfEntry.Text)
+		opts.Temperature, _ = parseFloats(temperatureEntry.Text)
+		opts.CompressionRatioThreshold, _ = strconv.ParseFloat(compressionEntry.Text, 64)
+		opts.NoSpeechThreshold, _ = strconv.ParseFloat(noSpeechEntry.Text, 64)
+		saveDecodeOptions(prefs, &opts)
+	}, w)
+}
+
+// newIntEntry returns an entry prefilled with v that only accepts positive whole numbers
+func newIntEntry(v int) *widget.Entry {
+	e := widget.NewEntry()
+	e.SetText(strconv.Itoa(v))
+	e.Validator = func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err == nil && n < 1 {
+			err = fmt.Errorf("must be at least 1")
+		}
+		return err
+	}
+	return e
+}
+
+func formatFloats(values []float64) string {
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseFloats parses a comma-separated list of at least one number
+func parseFloats(s string) ([]float64, error) {
+	var values []float64
+	for _, part := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,288 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Backend is a transcription engine. Implementations register themselves with
+// Register so they can be selected by name.
+type Backend interface {
+	// Load prepares the model, blocking until it is ready to transcribe.
+	Load(opts LoadOptions) error
+	// Transcribe runs req, giving up with ctx.Err() once ctx is done.
+	Transcribe(ctx context.Context, req Request) (Result, error)
+	Capabilities() Capabilities
+	// Info describes the loaded model and where it runs.
+	Info() BackendInfo
+	// Close releases the model and any helper process. It is safe to call after a failed Load.
+	Close()
+}
+
+// LoadOptions selects the model a backend loads and how it runs.
+type LoadOptions struct {
+	Model       string
+	UseGPU      bool
+	ComputeType string // precision such as "int8"; empty uses the backend default
+	// Exclusive makes Init close the active backend before loading, for when both models
+	// would not fit in memory at once
+	Exclusive bool
+}
+
+// Task selects what the model produces from the audio.
+type Task string
+
+const (
+	TaskTranscribe Task = "transcribe" // text in the spoken language
+	TaskTranslate  Task = "translate"  // English text, whatever the spoken language
+)
+
+// SampleRate is the only input rate Whisper models accept.
+const SampleRate = 16000
+
+// Request describes a single transcription job.
+type Request struct {
+	AudioFile string
+	// Samples, if set, is the audio itself: mono at SampleRate, in [-1, 1]. It is used
+	// instead of AudioFile, and backends that accept raw PCM get it without a trip to disk.
+	Samples []float32
+
+	Task           Task           // empty means TaskTranscribe
+	Language       string         // language code from Languages; empty auto-detects
+	InitialPrompt  string         // text the model treats as preceding the audio, to bias spelling and vocabulary
+	Decode         *DecodeOptions // nil leaves decoding to the backend defaults
+	Markers        []float64      // positions in seconds, shown inline at the nearest segment boundary
+	WordTimestamps bool           // fill Segment.Words; this costs extra decoding time
+
+	// Timeout is how long a worker may go without reporting progress or a result before
+	// the job is abandoned and the worker restarted; zero uses DefaultRequestTimeout.
+	Timeout time.Duration
+
+	// OnProgress, if set, is called from the transcribing goroutine as decoding advances.
+	// Backends that cannot report progress never call it.
+	OnProgress func(Progress)
+}
+
+// Capabilities describes what a backend supports, so the UI can adapt to it.
+type Capabilities struct {
+	Models       []string
+	DefaultModel string
+	GPU          bool
+	ComputeTypes []string // empty if the backend has no precision choice
+	// WordTimestamps reports whether Request.WordTimestamps is honoured.
+	WordTimestamps bool
+}
+
+// BackendInfo describes a loaded backend, for diagnostics.
+type BackendInfo struct {
+	Backend  string
+	Model    string
+	Device   string            // where the model runs, e.g. "cuda" or a server URL
+	DType    string            // numeric precision of inference, if known
+	Protocol int               // worker protocol version; 0 for backends without a worker
+	Versions map[string]string // component versions, e.g. "torch": "2.3.1"
+	Options  []string          // request options the backend honours
+}
+
+// Request options, as listed in BackendInfo.Options.
+const (
+	OptionTask           = "task"
+	OptionLanguage       = "language"
+	OptionInitialPrompt  = "initial_prompt"
+	OptionWordTimestamps = "word_timestamps"
+	OptionDecode         = "decode"
+	OptionProgress       = "progress"
+	OptionCancel         = "cancel"
+	OptionPCM            = "pcm"    // Request.Samples is sent as is rather than as a file
+	OptionStream         = "stream" // the worker keeps the sliding window of TranscribeStream
+)
+
+// Factory creates an unloaded backend.
+type Factory func() Backend
+
+var (
+	registry      = map[string]Factory{}
+	registryOrder []string
+
+	// loadMu serialises Init. initMu guards active and is only held briefly, so the
+	// active backend keeps serving while a replacement loads.
+	loadMu        sync.Mutex
+	initMu        sync.Mutex
+	active        *loaded
+	activeChanged = make(chan struct{}) // closed and replaced whenever active changes
+	retiring      sync.WaitGroup        // replaced backends still finishing their requests
+)
+
+// errNotInitialized is returned while no backend is active: before the first Init, and
+// while an exclusive Init loads.
+var errNotInitialized = errors.New("whisper not initialized")
+
+// loaded is the active backend, counting the requests it is serving so it is not
+// closed under them when it is replaced.
+type loaded struct {
+	Backend
+	name    string
+	running sync.WaitGroup
+}
+
+// Register makes a backend available under name. It is meant to be called from init functions.
+func Register(name string, factory Factory) {
+	if _, exists := registry[name]; exists {
+		panic("whisper: backend registered twice: " + name)
+	}
+	registry[name] = factory
+	registryOrder = append(registryOrder, name)
+}
+
+// Backends returns the names of the registered backends in registration order.
+func Backends() []string {
+	return append([]string(nil), registryOrder...)
+}
+
+// BackendCapabilities returns the capabilities of the named backend without loading it.
+func BackendCapabilities(name string) (Capabilities, error) {
+	factory, ok := registry[name]
+	if !ok {
+		return Capabilities{}, fmt.Errorf("unknown backend %q", name)
+	}
+	return factory().Capabilities(), nil
+}
+
+// Init loads a model on the named backend and makes it the active one once it is ready.
+// Until then the backend it replaces keeps serving requests, unless opts.Exclusive is
+// set; afterwards it finishes the requests it has in flight and is closed. If loading
+// fails the active backend stays as it was.
+func Init(backendName string, opts LoadOptions) error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	factory, ok := registry[backendName]
+	if !ok {
+		return fmt.Errorf("unknown backend %q", backendName)
+	}
+
+	if opts.Exclusive {
+		// Free the memory now; requests in flight fail
+		if old := swap(nil); old != nil {
+			old.Close()
+		}
+	}
+
+	b := factory()
+	if err := b.Load(opts); err != nil {
+		b.Close()
+		return err
+	}
+	retire(swap(&loaded{Backend: b, name: backendName}))
+	return nil
+}
+
+// swap makes l the active backend and returns the one it replaced.
+func swap(l *loaded) *loaded {
+	initMu.Lock()
+	defer initMu.Unlock()
+	old := active
+	active = l
+	close(activeChanged)
+	activeChanged = make(chan struct{})
+	return old
+}
+
+// retire closes l in the background once the requests it is serving have finished.
+func retire(l *loaded) {
+	if l == nil {
+		return
+	}
+	retiring.Add(1)
+	go func() {
+		defer retiring.Done()
+		l.running.Wait()
+		l.Close()
+	}()
+}
+
+// acquire returns the active backend, counted as busy until running.Done is called.
+func acquire() (*loaded, error) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if active == nil {
+		return nil, errNotInitialized
+	}
+	active.running.Add(1)
+	return active, nil
+}
+
+// activeState reports whether a backend is active, and returns the channel closed when
+// that changes.
+func activeState() (bool, <-chan struct{}) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	return active != nil, activeChanged
+}
+
+// Info describes the active backend.
+func Info() (BackendInfo, error) {
+	initMu.Lock()
+	defer initMu.Unlock()
+
+	if active == nil {
+		return BackendInfo{}, errNotInitialized
+	}
+	info := active.Info()
+	info.Backend = active.name
+	return info, nil
+}
+
+// Transcribe runs req on the active backend. Markers are placed in the result text
+// here, so backends only need to report segments.
+func Transcribe(req Request) (Result, error) {
+	return TranscribeContext(context.Background(), req)
+}
+
+// TranscribeContext is like Transcribe but abandons the job when ctx is cancelled,
+// returning ctx.Err(). The backend stays loaded and ready for the next request.
+func TranscribeContext(ctx context.Context, req Request) (Result, error) {
+	b, err := acquire()
+	if err != nil {
+		return Result{}, err
+	}
+	defer b.running.Done()
+
+	if req.Task == "" {
+		req.Task = TaskTranscribe
+	}
+
+	// Backends that only take files get the samples as a temporary WAV
+	if len(req.Samples) > 0 && !slices.Contains(b.Info().Options, OptionPCM) {
+		path, err := writeTempWav(req.Samples)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to write audio: %v", err)
+		}
+		defer os.Remove(path)
+		req.AudioFile, req.Samples = path, nil
+	}
+
+	result, err := b.Transcribe(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	result.Task = req.Task
+	result.Language = languageCode(result.Language)
+	if len(req.Markers) > 0 {
+		result.Text = insertMarkers(result.Text, result.Segments, req.Markers)
+	}
+	return result, nil
+}
+
+// Close shuts down the active backend, and waits for replaced ones to shut down.
+func Close() {
+	if old := swap(nil); old != nil {
+		old.Close()
+	}
+	retiring.Wait()
+}
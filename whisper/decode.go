@@ -0,0 +1,44 @@
+package whisper
+
+// DecodeOptions tunes how the model searches for a transcript. The JSON form is
+// forwarded to the worker, which passes it on to model.transcribe.
+type DecodeOptions struct {
+	BeamSize int `json:"beam_size"` // beams searched at temperature 0; 1 is greedy decoding
+	BestOf   int `json:"best_of"`   // candidates sampled at temperatures above 0
+	// Temperature is the fallback schedule: decoding is retried at the next
+	// temperature when the result fails the thresholds below.
+	Temperature []float64 `json:"temperature"`
+	FP16        bool      `json:"fp16"` // half precision inference; ignored on CPU and by backends with a compute type
+	// ConditionOnPreviousText feeds each window the text of the one before. It keeps
+	// long recordings consistent but lets a hallucination repeat itself.
+	ConditionOnPreviousText   bool    `json:"condition_on_previous_text"`
+	CompressionRatioThreshold float64 `json:"compression_ratio_threshold"` // gzip ratio above which text counts as repetitive
+	NoSpeechThreshold         float64 `json:"no_speech_threshold"`         // no-speech probability above which a window is skipped
+}
+
+// FastDecodeOptions decodes greedily without temperature fallback.
+func FastDecodeOptions() DecodeOptions {
+	return DecodeOptions{
+		BeamSize:                  1,
+		BestOf:                    1,
+		Temperature:               []float64{0},
+		FP16:                      true,
+		ConditionOnPreviousText:   true,
+		CompressionRatioThreshold: 2.4,
+		NoSpeechThreshold:         0.6,
+	}
+}
+
+// AccurateDecodeOptions uses beam search and the full fallback schedule, matching the
+// settings of the whisper command line tool.
+func AccurateDecodeOptions() DecodeOptions {
+	return DecodeOptions{
+		BeamSize:                  5,
+		BestOf:                    5,
+		Temperature:               []float64{0, 0.2, 0.4, 0.6, 0.8, 1},
+		FP16:                      true,
+		ConditionOnPreviousText:   true,
+		CompressionRatioThreshold: 2.4,
+		NoSpeechThreshold:         0.6,
+	}
+}
@@ -0,0 +1,93 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// postAudio uploads audioPath with the given form fields and returns the response body.
+// A non-2xx status is turned into an error, using the server's error message if it sent one.
+// Cancelling ctx aborts the upload or the wait for the response.
+func postAudio(ctx context.Context, client *http.Client, url, apiKey, audioPath string, fields map[string][]string) ([]byte, error) {
+	body, contentType, err := multipartBody(audioPath, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// OpenAI-style servers wrap the message as {"error": {"message": ...}}
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("server returned %s: %s", resp.Status, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	return raw, nil
+}
+
+// multipartBody builds a form upload with the audio file under "file" plus the given fields.
+func multipartBody(audioPath string, fields map[string][]string) (io.Reader, string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	for name, values := range fields {
+		for _, value := range values {
+			if err := form.WriteField(name, value); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	part, err := form.CreateFormFile("file", filepath.Base(audioPath))
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := os.Open(audioPath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(part, f); err != nil {
+		return nil, "", err
+	}
+
+	if err := form.Close(); err != nil {
+		return nil, "", err
+	}
+	return &body, form.FormDataContentType(), nil
+}
@@ -0,0 +1,60 @@
+package whisper
+
+import "strings"
+
+// Language is a language Whisper can transcribe.
+type Language struct {
+	Code string // ISO 639-1 code (or Whisper's own code where none exists)
+	Name string
+}
+
+// Languages lists every language supported by the Whisper models, in the order of
+// the model's tokenizer, which is roughly by amount of training data.
+var Languages = []Language{
+	{"en", "English"}, {"zh", "Chinese"}, {"de", "German"}, {"es", "Spanish"},
+	{"ru", "Russian"}, {"ko", "Korean"}, {"fr", "French"}, {"ja", "Japanese"},
+	{"pt", "Portuguese"}, {"tr", "Turkish"}, {"pl", "Polish"}, {"ca", "Catalan"},
+	{"nl", "Dutch"}, {"ar", "Arabic"}, {"sv", "Swedish"}, {"it", "Italian"},
+	{"id", "Indonesian"}, {"hi", "Hindi"}, {"fi", "Finnish"}, {"vi", "Vietnamese"},
+	{"he", "Hebrew"}, {"uk", "Ukrainian"}, {"el", "Greek"}, {"ms", "Malay"},
+	{"cs", "Czech"}, {"ro", "Romanian"}, {"da", "Danish"}, {"hu", "Hungarian"},
+	{"ta", "Tamil"}, {"no", "Norwegian"}, {"th", "Thai"}, {"ur", "Urdu"},
+	{"hr", "Croatian"}, {"bg", "Bulgarian"}, {"lt", "Lithuanian"}, {"la", "Latin"},
+	{"mi", "Maori"}, {"ml", "Malayalam"}, {"cy", "Welsh"}, {"sk", "Slovak"},
+	{"te", "Telugu"}, {"fa", "Persian"}, {"lv", "Latvian"}, {"bn", "Bengali"},
+	{"sr", "Serbian"}, {"az", "Azerbaijani"}, {"sl", "Slovenian"}, {"kn", "Kannada"},
+	{"et", "Estonian"}, {"mk", "Macedonian"}, {"br", "Breton"}, {"eu", "Basque"},
+	{"is", "Icelandic"}, {"hy", "Armenian"}, {"ne", "Nepali"}, {"mn", "Mongolian"},
+	{"bs", "Bosnian"}, {"kk", "Kazakh"}, {"sq", "Albanian"}, {"sw", "Swahili"},
+	{"gl", "Galician"}, {"mr", "Marathi"}, {"pa", "Punjabi"}, {"si", "Sinhala"},
+	{"km", "Khmer"}, {"sn", "Shona"}, {"yo", "Yoruba"}, {"so", "Somali"},
+	{"af", "Afrikaans"}, {"oc", "Occitan"}, {"ka", "Georgian"}, {"be", "Belarusian"},
+	{"tg", "Tajik"}, {"sd", "Sindhi"}, {"gu", "Gujarati"}, {"am", "Amharic"},
+	{"yi", "Yiddish"}, {"lo", "Lao"}, {"uz", "Uzbek"}, {"fo", "Faroese"},
+	{"ht", "Haitian Creole"}, {"ps", "Pashto"}, {"tk", "Turkmen"}, {"nn", "Nynorsk"},
+	{"mt", "Maltese"}, {"sa", "Sanskrit"}, {"lb", "Luxembourgish"}, {"my", "Myanmar"},
+	{"bo", "Tibetan"}, {"tl", "Tagalog"}, {"mg", "Malagasy"}, {"as", "Assamese"},
+	{"tt", "Tatar"}, {"haw", "Hawaiian"}, {"ln", "Lingala"}, {"ha", "Hausa"},
+	{"ba", "Bashkir"}, {"jw", "Javanese"}, {"su", "Sundanese"}, {"yue", "Cantonese"},
+}
+
+// LanguageName returns the display name for a language code, or the code itself if unknown.
+func LanguageName(code string) string {
+	for _, l := range Languages {
+		if l.Code == code {
+			return l.Name
+		}
+	}
+	return code
+}
+
+// languageCode normalises a language reported by a backend, which may be a code
+// ("en") or a lower-case name ("english"), to its code.
+func languageCode(s string) string {
+	for _, l := range Languages {
+		if strings.EqualFold(l.Code, s) || strings.EqualFold(l.Name, s) {
+			return l.Code
+		}
+	}
+	return s
+}
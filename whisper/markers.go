@@ -0,0 +1,52 @@
+package whisper
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// insertMarkers joins segment texts, placing each marker at the nearest segment boundary.
+// Without segments there are no boundaries, so the markers follow text instead.
+func insertMarkers(text string, segments []Segment, markers []float64) string {
+	if len(segments) == 0 {
+		parts := []string{text}
+		for i, m := range markers {
+			parts = append(parts, formatMarker(i+1, m))
+		}
+		return strings.TrimSpace(strings.Join(parts, " "))
+	}
+
+	// Boundary i is the start of segment i; the final boundary is the end of the last segment
+	boundaries := make([]float64, 0, len(segments)+1)
+	for _, seg := range segments {
+		boundaries = append(boundaries, seg.Start)
+	}
+	boundaries = append(boundaries, segments[len(segments)-1].End)
+
+	placed := make([][]string, len(boundaries))
+	for i, m := range markers {
+		nearest := 0
+		for b := range boundaries {
+			if math.Abs(boundaries[b]-m) < math.Abs(boundaries[nearest]-m) {
+				nearest = b
+			}
+		}
+		placed[nearest] = append(placed[nearest], formatMarker(i+1, m))
+	}
+
+	var parts []string
+	for i, seg := range segments {
+		parts = append(parts, placed[i]...)
+		if text := strings.TrimSpace(seg.Text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	parts = append(parts, placed[len(placed)-1]...)
+	return strings.Join(parts, " ")
+}
+
+func formatMarker(number int, seconds float64) string {
+	secs := int(seconds)
+	return fmt.Sprintf("[Mark %d @ %d:%02d]", number, secs/60, secs%60)
+}
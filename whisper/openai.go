@@ -0,0 +1,190 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	transcriptionsPath = "/v1/audio/transcriptions"
+	translationsPath   = "/v1/audio/translations"
+)
+
+func init() {
+	Register("openai-api", func() Backend {
+		return newHTTPBackend(httpConfig{
+			BaseURL:        os.Getenv("WHISPER_API_URL"),
+			APIKey:         os.Getenv("WHISPER_API_KEY"),
+			Models:         splitList(os.Getenv("WHISPER_API_MODELS")),
+			Language:       os.Getenv("WHISPER_API_LANGUAGE"),
+			ResponseFormat: os.Getenv("WHISPER_API_RESPONSE_FORMAT"),
+		}, http.DefaultClient)
+	})
+}
+
+// httpConfig holds the settings of an OpenAI-compatible server. Empty fields use defaults.
+type httpConfig struct {
+	BaseURL        string
+	APIKey         string
+	Models         []string
+	Language       string // used when a request does not name a language
+	ResponseFormat string // verbose_json (default), json or text; only verbose_json has timings
+}
+
+// httpBackend posts recordings to an OpenAI-compatible /v1/audio/transcriptions (or
+// /v1/audio/translations) endpoint, such as a speech server on another machine in the LAN.
+//
+// It is configured from the environment: WHISPER_API_URL (default
+// http://localhost:8000), WHISPER_API_KEY, WHISPER_API_MODELS (comma-separated,
+// default whisper-1), WHISPER_API_LANGUAGE and WHISPER_API_RESPONSE_FORMAT.
+type httpBackend struct {
+	cfg    httpConfig
+	model  string
+	client *http.Client
+	mu     sync.Mutex
+}
+
+// openAIResponse covers the json and verbose_json response formats.
+type openAIResponse struct {
+	Text     string    `json:"text"`
+	Language string    `json:"language,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Segments []Segment `json:"segments,omitempty"`
+	Words    []Word    `json:"words,omitempty"` // reported separately from segments
+}
+
+func newHTTPBackend(cfg httpConfig, client *http.Client) *httpBackend {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:8000"
+	}
+	// Accept both http://host:port and http://host:port/v1
+	cfg.BaseURL = strings.TrimSuffix(strings.TrimSuffix(cfg.BaseURL, "/"), "/v1")
+
+	if len(cfg.Models) == 0 {
+		cfg.Models = []string{"whisper-1"}
+	}
+	return &httpBackend{cfg: cfg, client: client}
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (b *httpBackend) Capabilities() Capabilities {
+	// The server decides where the model runs, so there is no GPU choice to offer
+	return Capabilities{Models: b.cfg.Models, DefaultModel: b.cfg.Models[0], WordTimestamps: true}
+}
+
+// Load only checks that the server is reachable; the model is chosen per request.
+func (b *httpBackend) Load(opts LoadOptions) error {
+	b.model = opts.Model
+
+	client := *b.client
+	client.Timeout = 10 * time.Second
+	req, err := http.NewRequest(http.MethodGet, b.cfg.BaseURL+"/v1/models", nil)
+	if err != nil {
+		return err
+	}
+	if b.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+b.cfg.APIKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("transcription server not reachable at %s: %v", b.cfg.BaseURL, err)
+	}
+	resp.Body.Close()
+
+	// Not every server lists models, so any HTTP answer will do except a rejected key
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("transcription server rejected the API key: %s", resp.Status)
+	}
+	return nil
+}
+
+func (b *httpBackend) Transcribe(ctx context.Context, r Request) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Not every server supports verbose_json, so the format can be downgraded in the config
+	format := b.cfg.ResponseFormat
+	if format == "" {
+		format = "verbose_json"
+	}
+
+	fields := map[string][]string{
+		"model":           {b.model},
+		"response_format": {format},
+	}
+	if r.InitialPrompt != "" {
+		fields["prompt"] = []string{r.InitialPrompt}
+	}
+	if r.WordTimestamps {
+		fields["timestamp_granularities[]"] = []string{"segment", "word"}
+	}
+	// The API only takes a single temperature; 0 lets the server apply its own fallback
+	if r.Decode != nil && len(r.Decode.Temperature) > 0 {
+		fields["temperature"] = []string{strconv.FormatFloat(r.Decode.Temperature[0], 'f', -1, 64)}
+	}
+
+	// Translations have their own endpoint, which always produces English and takes no language
+	path := transcriptionsPath
+	if r.Task == TaskTranslate {
+		path = translationsPath
+	} else {
+		language := r.Language
+		if language == "" {
+			language = b.cfg.Language
+		}
+		if language != "" {
+			fields["language"] = []string{language}
+		}
+	}
+
+	raw, err := postAudio(ctx, b.client, b.cfg.BaseURL+path, b.cfg.APIKey, r.AudioFile, fields)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Plain text formats are returned as-is
+	if format != "json" && format != "verbose_json" {
+		return Result{Text: strings.TrimSpace(string(raw))}, nil
+	}
+
+	var resp openAIResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return Result{}, fmt.Errorf("failed to parse response: %v", err)
+	}
+	assignWords(resp.Segments, resp.Words)
+
+	return Result{
+		Text:     strings.TrimSpace(resp.Text),
+		Segments: resp.Segments,
+		Language: resp.Language,
+		Duration: resp.Duration,
+	}, nil
+}
+
+func (b *httpBackend) Info() BackendInfo {
+	return BackendInfo{
+		Model:   b.model,
+		Device:  b.cfg.BaseURL,
+		Options: []string{OptionTask, OptionLanguage, OptionInitialPrompt, OptionWordTimestamps, OptionDecode, OptionCancel},
+	}
+}
+
+// Close has nothing to release; the server outlives the app.
+func (b *httpBackend) Close() {}
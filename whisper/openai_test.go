@@ -0,0 +1,251 @@
+package whisper
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeServer answers every request with status and body, recording the last upload.
+type fakeServer struct {
+	*httptest.Server
+	path   string
+	auth   string
+	fields map[string][]string
+	audio  string
+}
+
+func newFakeServer(t *testing.T, status int, body string) *fakeServer {
+	t.Helper()
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.path = r.URL.Path
+		s.auth = r.Header.Get("Authorization")
+		if r.Method == http.MethodPost {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("malformed upload: %v", err)
+			} else {
+				s.fields = r.MultipartForm.Value
+				if f, _, err := r.FormFile("file"); err == nil {
+					b, _ := io.ReadAll(f)
+					s.audio = string(b)
+					f.Close()
+				}
+			}
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) backend(cfg httpConfig) *httpBackend {
+	cfg.BaseURL = s.URL + "/v1/"
+	b := newHTTPBackend(cfg, s.Client())
+	b.model = "whisper-1"
+	return b
+}
+
+func tempAudio(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "take.wav")
+	if err := os.WriteFile(path, []byte("RIFF audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const verboseResponse = `{
+	"text": " Hello there. General Kenobi.",
+	"language": "english",
+	"duration": 4.5,
+	"segments": [
+		{"id": 0, "start": 0, "end": 2, "text": " Hello there.", "avg_logprob": -0.2, "no_speech_prob": 0.01},
+		{"id": 1, "start": 2, "end": 4.5, "text": " General Kenobi.", "avg_logprob": -0.3, "no_speech_prob": 0.02}
+	],
+	"words": [
+		{"word": "Hello", "start": 0, "end": 0.8, "probability": 0.9},
+		{"word": "there.", "start": 0.8, "end": 1.9},
+		{"word": "General", "start": 2.1, "end": 3},
+		{"word": "Kenobi.", "start": 3, "end": 4.4}
+	]
+}`
+
+func TestHTTPTranscribeFields(t *testing.T) {
+	s := newFakeServer(t, http.StatusOK, verboseResponse)
+	b := s.backend(httpConfig{APIKey: "secret", Language: "de"})
+
+	_, err := b.Transcribe(context.Background(), Request{
+		AudioFile:      tempAudio(t),
+		Task:           TaskTranscribe,
+		Language:       "en",
+		InitialPrompt:  "Kenobi",
+		WordTimestamps: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.path != transcriptionsPath {
+		t.Errorf("posted to %s, want %s", s.path, transcriptionsPath)
+	}
+	if s.auth != "Bearer secret" {
+		t.Errorf("Authorization %q", s.auth)
+	}
+	if s.audio != "RIFF audio" {
+		t.Errorf("uploaded file %q", s.audio)
+	}
+	want := map[string][]string{
+		"model":                     {"whisper-1"},
+		"response_format":           {"verbose_json"},
+		"language":                  {"en"}, // the request's language wins over the config's
+		"prompt":                    {"Kenobi"},
+		"timestamp_granularities[]": {"segment", "word"},
+	}
+	for name, values := range want {
+		if !slices.Equal(s.fields[name], values) {
+			t.Errorf("field %s = %q, want %q", name, s.fields[name], values)
+		}
+	}
+}
+
+func TestHTTPTranscribeDefaults(t *testing.T) {
+	s := newFakeServer(t, http.StatusOK, verboseResponse)
+	b := s.backend(httpConfig{Language: "de"})
+
+	if _, err := b.Transcribe(context.Background(), Request{AudioFile: tempAudio(t), Task: TaskTranscribe}); err != nil {
+		t.Fatal(err)
+	}
+	if s.auth != "" {
+		t.Errorf("Authorization %q sent without a key", s.auth)
+	}
+	if got := s.fields["language"]; !slices.Equal(got, []string{"de"}) {
+		t.Errorf("language %q, want the configured de", got)
+	}
+	for _, name := range []string{"prompt", "timestamp_granularities[]", "temperature"} {
+		if _, ok := s.fields[name]; ok {
+			t.Errorf("unrequested field %s sent", name)
+		}
+	}
+}
+
+func TestHTTPTranslate(t *testing.T) {
+	s := newFakeServer(t, http.StatusOK, verboseResponse)
+	b := s.backend(httpConfig{Language: "de"})
+
+	if _, err := b.Transcribe(context.Background(), Request{AudioFile: tempAudio(t), Task: TaskTranslate, Language: "fr"}); err != nil {
+		t.Fatal(err)
+	}
+	if s.path != translationsPath {
+		t.Errorf("posted to %s, want %s", s.path, translationsPath)
+	}
+	if _, ok := s.fields["language"]; ok {
+		t.Errorf("language %q sent to the translations endpoint", s.fields["language"])
+	}
+}
+
+func TestHTTPVerboseJSON(t *testing.T) {
+	s := newFakeServer(t, http.StatusOK, verboseResponse)
+	b := s.backend(httpConfig{})
+
+	result, err := b.Transcribe(context.Background(), Request{AudioFile: tempAudio(t), WordTimestamps: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "Hello there. General Kenobi." || result.Language != "english" || result.Duration != 4.5 {
+		t.Errorf("result %+v", result)
+	}
+	if len(result.Segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(result.Segments))
+	}
+	if seg := result.Segments[1]; seg.Start != 2 || seg.End != 4.5 || seg.Text != " General Kenobi." || seg.AvgLogprob != -0.3 {
+		t.Errorf("segment %+v", seg)
+	}
+
+	// The flat word list is split between the segments by start time
+	var words [2][]string
+	for i, seg := range result.Segments {
+		for _, w := range seg.Words {
+			words[i] = append(words[i], w.Word)
+		}
+	}
+	if !slices.Equal(words[0], []string{"Hello", "there."}) || !slices.Equal(words[1], []string{"General", "Kenobi."}) {
+		t.Errorf("words %q", words)
+	}
+}
+
+func TestHTTPPlainText(t *testing.T) {
+	s := newFakeServer(t, http.StatusOK, " just the text \n")
+	b := s.backend(httpConfig{ResponseFormat: "text"})
+
+	result, err := b.Transcribe(context.Background(), Request{AudioFile: tempAudio(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Text != "just the text" || result.Segments != nil {
+		t.Errorf("result %+v", result)
+	}
+	if got := s.fields["response_format"]; !slices.Equal(got, []string{"text"}) {
+		t.Errorf("response_format %q", got)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusBadRequest, `{"error":{"message":"Invalid file format.","type":"invalid_request_error"}}`, "400 Bad Request: Invalid file format."},
+		{http.StatusInternalServerError, "model crashed\n", "500 Internal Server Error: model crashed"},
+	}
+	for _, tt := range tests {
+		s := newFakeServer(t, tt.status, tt.body)
+		_, err := s.backend(httpConfig{}).Transcribe(context.Background(), Request{AudioFile: tempAudio(t)})
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("status %d: got %v, want an error ending %q", tt.status, err, tt.want)
+		}
+	}
+}
+
+func TestHTTPLoad(t *testing.T) {
+	tests := []struct {
+		status int
+		ok     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNotFound, true}, // servers without a model list are fine
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		s := newFakeServer(t, tt.status, "")
+		b := s.backend(httpConfig{APIKey: "secret"})
+		err := b.Load(LoadOptions{Model: "large-v3"})
+		if s.path != "/v1/models" || s.auth != "Bearer secret" {
+			t.Errorf("status %d: checked %s with %q", tt.status, s.path, s.auth)
+		}
+		if tt.ok && err != nil {
+			t.Errorf("status %d: %v", tt.status, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "rejected the API key")) {
+			t.Errorf("status %d: got %v, want the key to be rejected", tt.status, err)
+		}
+		if b.model != "large-v3" {
+			t.Errorf("model %q", b.model)
+		}
+	}
+
+	s := newFakeServer(t, http.StatusOK, "")
+	s.Close()
+	if err := s.backend(httpConfig{}).Load(LoadOptions{}); err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Errorf("stopped server: got %v", err)
+	}
+}
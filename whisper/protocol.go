@@ -0,0 +1,248 @@
+package whisper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// protocolVersion is the worker protocol spoken here. Workers report theirs in READY
+// and are refused if it differs.
+const protocolVersion = 1
+
+// Message kinds of the worker protocol. Each line a worker writes to stdout is one JSON
+// message; PROGRESS, RESULT and ERROR carry the id of the request they answer.
+const (
+	msgReady    = "READY"    // the model is loaded; sent once at startup
+	msgProgress = "PROGRESS" // decoding has advanced
+	msgResult   = "RESULT"   // final answer to a request
+	msgError    = "ERROR"    // final answer to a request that failed, or a failed startup
+	msgLog      = "LOG"      // diagnostic output, not tied to a request
+)
+
+// errCodeCancelled marks an ERROR answering a request that was interrupted with SIGINT.
+const errCodeCancelled = "cancelled"
+
+type workerRequest struct {
+	ID             int64          `json:"id"`
+	AudioFile      string         `json:"audio_file,omitempty"`
+	PCM            *pcmFrame      `json:"pcm,omitempty"`
+	Task           Task           `json:"task,omitempty"`
+	Language       string         `json:"language,omitempty"`
+	InitialPrompt  string         `json:"initial_prompt,omitempty"`
+	WordTimestamps bool           `json:"word_timestamps,omitempty"`
+	Decode         *DecodeOptions `json:"decode,omitempty"`
+	Stream         *streamFrame   `json:"stream,omitempty"`
+}
+
+// streamFrame makes a request one pass of a live stream. The worker keeps a sliding
+// window per stream id: the request's PCM is appended to it and decoded, and the RESULT
+// carries the segments that became final (Segments) and the provisional ones after
+// them (Provisional), in stream time.
+type streamFrame struct {
+	ID     string `json:"id"`
+	Offset int    `json:"offset"`         // stream position of the request's first sample
+	End    bool   `json:"end,omitempty"`  // no audio follows: make everything final and drop the window
+	Drop   bool   `json:"drop,omitempty"` // the stream was abandoned: drop the window without decoding
+}
+
+// pcmFrame announces raw audio following the request line on stdin: exactly Bytes bytes
+// of mono samples at SampleRate, as little-endian float32 ("f32le") or int16 ("s16le").
+type pcmFrame struct {
+	Format string `json:"format"`
+	Bytes  int    `json:"bytes"`
+}
+
+// encodePCM packs samples as an f32le frame.
+func encodePCM(samples []float32) (*pcmFrame, []byte) {
+	data := make([]byte, len(samples)*4)
+	for i, s := range samples {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(s))
+	}
+	return &pcmFrame{Format: "f32le", Bytes: len(data)}, data
+}
+
+type workerMessage struct {
+	Type string `json:"type"`
+	ID   int64  `json:"id,omitempty"`
+
+	// RESULT; PROGRESS uses Segments (new ones only) and Duration as well
+	Text                string    `json:"text,omitempty"`
+	Segments            []Segment `json:"segments,omitempty"`
+	Language            string    `json:"language,omitempty"`
+	LanguageProbability float64   `json:"language_probability,omitempty"`
+	Duration            float64   `json:"duration,omitempty"`
+
+	// PROGRESS
+	Processed float64 `json:"processed,omitempty"`
+
+	// RESULT of a stream pass
+	Provisional []Segment `json:"provisional,omitempty"`
+
+	// ERROR
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+
+	// LOG
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// READY
+	Protocol int               `json:"protocol,omitempty"`
+	Model    string            `json:"model,omitempty"`
+	Device   string            `json:"device,omitempty"`
+	DType    string            `json:"dtype,omitempty"`
+	Versions map[string]string `json:"versions,omitempty"`
+	Options  []string          `json:"options,omitempty"`
+}
+
+// requestOptions lists the options r uses that a worker must support.
+func requestOptions(r Request) []string {
+	var opts []string
+	if r.Task == TaskTranslate {
+		opts = append(opts, OptionTask)
+	}
+	if r.Language != "" {
+		opts = append(opts, OptionLanguage)
+	}
+	if r.InitialPrompt != "" {
+		opts = append(opts, OptionInitialPrompt)
+	}
+	if r.WordTimestamps {
+		opts = append(opts, OptionWordTimestamps)
+	}
+	if r.Decode != nil {
+		opts = append(opts, OptionDecode)
+	}
+	if len(r.Samples) > 0 {
+		opts = append(opts, OptionPCM)
+	}
+	return opts
+}
+
+// Responses carry every segment on one line, which easily outgrows the default 64KB
+const maxResponseSize = 64 * 1024 * 1024
+
+// workerConn is the connection to one worker process. A reader goroutine dispatches each
+// message to the call waiting for its id, so a stray or malformed line is skipped instead
+// of being taken for the answer to whatever request happens to be waiting.
+type workerConn struct {
+	stdin      io.WriteCloser
+	stdoutFile *os.File
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]*workerCall
+	closed  chan struct{} // closed when the worker's stdout ends
+}
+
+// workerCall is a request awaiting its answer. Progress only ever holds the latest
+// report; final receives the RESULT or ERROR.
+type workerCall struct {
+	id       int64
+	progress chan Progress
+	final    chan workerMessage
+	decoded  []Segment // segments from PROGRESS messages so far, owned by the reader
+}
+
+func newWorkerConn(stdin io.WriteCloser, stdoutFile *os.File, stdout *bufio.Scanner) *workerConn {
+	c := &workerConn{
+		stdin:      stdin,
+		stdoutFile: stdoutFile,
+		pending:    map[int64]*workerCall{},
+		closed:     make(chan struct{}),
+	}
+	go c.readLoop(stdout)
+	return c
+}
+
+// readWorkerMessage parses one line, printing LOG messages rather than returning them.
+func readWorkerMessage(line []byte) (workerMessage, bool) {
+	var msg workerMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		fmt.Fprintf(os.Stderr, "worker: ignoring malformed line: %.200s\n", line)
+		return msg, false
+	}
+	if msg.Type == msgLog {
+		fmt.Fprintf(os.Stderr, "worker %s: %s\n", msg.Level, msg.Message)
+		return msg, false
+	}
+	return msg, true
+}
+
+func (c *workerConn) readLoop(stdout *bufio.Scanner) {
+	defer close(c.closed)
+
+	for stdout.Scan() {
+		msg, ok := readWorkerMessage(stdout.Bytes())
+		if !ok {
+			continue
+		}
+
+		c.mu.Lock()
+		call := c.pending[msg.ID]
+		if call != nil && msg.Type != msgProgress {
+			// A request gets one answer; anything after it is stray
+			delete(c.pending, msg.ID)
+		}
+		c.mu.Unlock()
+
+		if call == nil {
+			fmt.Fprintf(os.Stderr, "worker: ignoring %s for unknown request %d\n", msg.Type, msg.ID)
+			continue
+		}
+
+		switch msg.Type {
+		case msgProgress:
+			call.decoded = append(call.decoded, msg.Segments...)
+			p := Progress{Processed: msg.Processed, Duration: msg.Duration, Segments: call.decoded}
+			// Replace an unread report; each one supersedes the last
+			select {
+			case <-call.progress:
+			default:
+			}
+			call.progress <- p
+		case msgResult, msgError:
+			call.final <- msg
+		default:
+			fmt.Fprintf(os.Stderr, "worker: ignoring unexpected %s message\n", msg.Type)
+		}
+	}
+}
+
+// send writes req under a new id, followed by payload if it carries a PCM frame, and
+// returns the call that will receive its answer.
+func (c *workerConn) send(req workerRequest, payload []byte) (*workerCall, error) {
+	c.mu.Lock()
+	c.nextID++
+	call := &workerCall{
+		id:       c.nextID,
+		progress: make(chan Progress, 1),
+		final:    make(chan workerMessage, 1),
+	}
+	c.pending[call.id] = call
+	c.mu.Unlock()
+
+	req.ID = call.id
+	data, err := json.Marshal(req)
+	if err == nil {
+		_, err = c.stdin.Write(append(append(data, '\n'), payload...))
+	}
+	if err != nil {
+		c.forget(call)
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	return call, nil
+}
+
+// forget stops waiting for call; an answer arriving later is ignored.
+func (c *workerConn) forget(call *workerCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, call.id)
+}
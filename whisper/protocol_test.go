@@ -0,0 +1,374 @@
+package whisper
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWorker is the far end of a workerConn: the test reads requests from its stdin and
+// writes whatever lines it likes to its stdout.
+type fakeWorker struct {
+	conn     *workerConn
+	requests *bufio.Reader
+	stdout   *os.File
+}
+
+func newFakeWorker(t *testing.T) *fakeWorker {
+	t.Helper()
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdinR.Close()
+		stdinW.Close()
+		stdoutR.Close()
+		stdoutW.Close()
+	})
+
+	stdout := bufio.NewScanner(stdoutR)
+	stdout.Buffer(make([]byte, 0, 64*1024), maxResponseSize)
+	return &fakeWorker{
+		conn:     newWorkerConn(stdinW, stdoutR, stdout),
+		requests: bufio.NewReader(stdinR),
+		stdout:   stdoutW,
+	}
+}
+
+// request reads the next request line the worker received.
+func (w *fakeWorker) request(t *testing.T) workerRequest {
+	t.Helper()
+	line, err := w.requests.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("no request received: %v", err)
+	}
+	var req workerRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		t.Fatalf("malformed request %q: %v", line, err)
+	}
+	return req
+}
+
+// samples reads the PCM frame that follows req.
+func (w *fakeWorker) samples(t *testing.T, req workerRequest) []float32 {
+	t.Helper()
+	if req.PCM == nil {
+		return nil
+	}
+	raw := make([]byte, req.PCM.Bytes)
+	if _, err := io.ReadFull(w.requests, raw); err != nil {
+		t.Fatalf("PCM frame: %v", err)
+	}
+	out := make([]float32, len(raw)/4)
+	for i := range out {
+		out[i] = math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:]))
+	}
+	return out
+}
+
+func (w *fakeWorker) write(t *testing.T, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := w.stdout.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (w *fakeWorker) send(t *testing.T) *workerCall {
+	t.Helper()
+	call, err := w.conn.send(workerRequest{AudioFile: "take.wav"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := w.request(t); req.ID != call.id || req.AudioFile != "take.wav" {
+		t.Fatalf("worker got %+v, want id %d for take.wav", req, call.id)
+	}
+	return call
+}
+
+func waitFinal(t *testing.T, call *workerCall) workerMessage {
+	t.Helper()
+	select {
+	case msg := <-call.final:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no answer for request %d", call.id)
+		return workerMessage{}
+	}
+}
+
+func TestWorkerConnSkipsNoise(t *testing.T) {
+	w := newFakeWorker(t)
+	call := w.send(t)
+
+	w.write(t,
+		"Traceback (most recent call last):",
+		`{"type":"LOG","level":"warning","message":"FP16 is not supported on CPU"}`,
+		`{"type":"RESULT","id":99,"text":"someone else's"}`,
+		fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"hello"}`, call.id),
+	)
+
+	msg := waitFinal(t, call)
+	if msg.Type != msgResult || msg.Text != "hello" {
+		t.Fatalf("got %+v, want the RESULT for request %d", msg, call.id)
+	}
+}
+
+func TestWorkerConnCoalescesProgress(t *testing.T) {
+	w := newFakeWorker(t)
+	call := w.send(t)
+
+	for i := 1; i <= 3; i++ {
+		w.write(t, fmt.Sprintf(`{"type":"PROGRESS","id":%d,"processed":%d,"duration":3,"segments":[{"id":%d,"text":"s%d"}]}`, call.id, i, i, i))
+	}
+	w.write(t, fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"done"}`, call.id))
+	waitFinal(t, call)
+
+	// The reader handled every line before the RESULT, so only the last report is left
+	select {
+	case p := <-call.progress:
+		if p.Processed != 3 || p.Duration != 3 {
+			t.Errorf("progress %v/%v, want 3/3", p.Processed, p.Duration)
+		}
+		if len(p.Segments) != 3 || p.Segments[2].Text != "s3" {
+			t.Errorf("progress segments %+v, want all three so far", p.Segments)
+		}
+	default:
+		t.Fatal("no progress report")
+	}
+	select {
+	case p := <-call.progress:
+		t.Fatalf("stale progress report %+v left over", p)
+	default:
+	}
+}
+
+func TestWorkerConnDispatchesByID(t *testing.T) {
+	w := newFakeWorker(t)
+	first := w.send(t)
+	second := w.send(t)
+
+	// Answered out of order
+	w.write(t,
+		fmt.Sprintf(`{"type":"ERROR","id":%d,"error":"boom"}`, second.id),
+		fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"first"}`, first.id),
+	)
+
+	if msg := waitFinal(t, first); msg.Type != msgResult || msg.Text != "first" {
+		t.Errorf("first request got %+v", msg)
+	}
+	if msg := waitFinal(t, second); msg.Type != msgError || msg.Error != "boom" {
+		t.Errorf("second request got %+v", msg)
+	}
+
+	// A second answer to a request is stray and must not reach anyone
+	w.write(t, fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"again"}`, first.id))
+	third := w.send(t)
+	w.write(t, fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"third"}`, third.id))
+	if msg := waitFinal(t, third); msg.Text != "third" {
+		t.Errorf("third request got %+v", msg)
+	}
+	select {
+	case msg := <-first.final:
+		t.Errorf("answered request got a second answer %+v", msg)
+	default:
+	}
+}
+
+func TestWorkerConnForgottenCall(t *testing.T) {
+	w := newFakeWorker(t)
+	call := w.send(t)
+	w.conn.forget(call)
+
+	next := w.send(t)
+	w.write(t,
+		fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"late"}`, call.id),
+		fmt.Sprintf(`{"type":"RESULT","id":%d,"text":"next"}`, next.id),
+	)
+	if msg := waitFinal(t, next); msg.Text != "next" {
+		t.Fatalf("got %+v", msg)
+	}
+	select {
+	case msg := <-call.final:
+		t.Fatalf("forgotten request got %+v", msg)
+	default:
+	}
+}
+
+func TestWorkerConnClosedOnEOF(t *testing.T) {
+	w := newFakeWorker(t)
+	w.stdout.Close()
+
+	select {
+	case <-w.conn.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closed did not fire when stdout ended")
+	}
+}
+
+func TestWorkerConnSendsPCM(t *testing.T) {
+	w := newFakeWorker(t)
+	frame, payload := encodePCM([]float32{0, 0.5, -1})
+	if frame.Format != "f32le" || frame.Bytes != 12 || len(payload) != 12 {
+		t.Fatalf("frame %+v with %d bytes", frame, len(payload))
+	}
+
+	call, err := w.conn.send(workerRequest{PCM: frame}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := w.request(t)
+	if req.ID != call.id || req.PCM == nil || req.PCM.Bytes != 12 || req.AudioFile != "" {
+		t.Fatalf("worker got %+v", req)
+	}
+	if got := w.samples(t, req); !slices.Equal(got, []float32{0, 0.5, -1}) {
+		t.Errorf("worker read samples %v", got)
+	}
+}
+
+// fakeReadyWorker starts like a real worker but announces the given READY message.
+func fakeReadyWorker(ready string) []byte {
+	return []byte(fmt.Sprintf(`import sys
+print(%q, flush=True)
+for line in sys.stdin:
+    pass
+`, ready))
+}
+
+func TestStartRefusesOtherProtocolVersions(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Setenv("PYTHON_ENV", "")
+
+	for _, ready := range []string{
+		`{"type":"READY"}`,
+		fmt.Sprintf(`{"type":"READY","protocol":%d}`, protocolVersion+1),
+	} {
+		b := &workerBackend{script: fakeReadyWorker(ready)}
+		err := b.Load(LoadOptions{Model: "tiny"})
+		b.Close()
+		if err == nil || !strings.Contains(err.Error(), "protocol version") {
+			t.Errorf("READY %s: got %v, want a protocol version error", ready, err)
+		}
+	}
+
+	b := &workerBackend{script: fakeReadyWorker(fmt.Sprintf(`{"type":"READY","protocol":%d,"model":"tiny","options":["task"]}`, protocolVersion))}
+	defer b.Close()
+	if err := b.Load(LoadOptions{Model: "tiny"}); err != nil {
+		t.Fatalf("compatible worker refused: %v", err)
+	}
+	if info := b.Info(); info.Model != "tiny" || info.Protocol != protocolVersion {
+		t.Errorf("info %+v", info)
+	}
+}
+
+func TestStartAfterStopInstallsNoConn(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Setenv("PYTHON_ENV", "")
+
+	b := &workerBackend{script: fakeReadyWorker(fmt.Sprintf(`{"type":"READY","protocol":%d}`, protocolVersion))}
+	if err := b.Load(LoadOptions{Model: "tiny"}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// A restart that finishes loading after Close has stopped the supervisor
+	first := b.conn
+	b.sup.stop(func() { first.stdin.Close() })
+	if _, err := b.start(); err == nil {
+		t.Fatal("start succeeded on a stopped backend")
+	}
+	if b.conn != first {
+		t.Error("start replaced the conn of a stopped backend")
+	}
+}
+
+// protocolWorker serves requests through the shared worker_protocol module.
+var protocolWorker = []byte(`from worker_protocol import ready, serve
+
+ready(model="tiny", device="cpu", dtype="float32", versions={})
+
+def transcribe(req_id, req, audio):
+    if isinstance(audio, str):
+        raise ValueError("cannot open " + audio)
+    return {"text": "%d samples, max %.1f" % (len(audio), max(audio)), "segments": []}
+
+serve(transcribe)
+`)
+
+func TestWorkerProtocolModule(t *testing.T) {
+	if err := exec.Command("python3", "-c", "import numpy").Run(); err != nil {
+		t.Skip("python3 with numpy not found")
+	}
+	t.Setenv("PYTHON_ENV", "")
+
+	b := &workerBackend{script: protocolWorker}
+	if err := b.Load(LoadOptions{Model: "tiny"}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if info := b.Info(); info.Protocol != protocolVersion || !slices.Contains(info.Options, OptionPCM) {
+		t.Errorf("info %+v", info)
+	}
+
+	result, err := b.Transcribe(context.Background(), Request{Samples: []float32{0, 0.5, -1}})
+	if err != nil || result.Text != "3 samples, max 0.5" {
+		t.Errorf("got %q, %v", result.Text, err)
+	}
+	if _, err := b.Transcribe(context.Background(), Request{AudioFile: "take.mp3"}); err == nil || !strings.Contains(err.Error(), "cannot open take.mp3") {
+		t.Errorf("got %v, want the worker's error", err)
+	}
+}
+
+func TestStartKillsHungWorker(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not found")
+	}
+	t.Setenv("PYTHON_ENV", "")
+	defer func(d time.Duration) { workerStartTimeout = d }(workerStartTimeout)
+	workerStartTimeout = 300 * time.Millisecond
+
+	// Output keeps a slow load alive; silence does not
+	b := &workerBackend{script: []byte(fmt.Sprintf(`import sys, time
+for i in range(4):
+    print("loading", i, file=sys.stderr, flush=True)
+    time.sleep(0.2)
+print(%q, flush=True)
+for line in sys.stdin:
+    pass
+`, fmt.Sprintf(`{"type":"READY","protocol":%d}`, protocolVersion)))}
+	if err := b.Load(LoadOptions{Model: "tiny"}); err != nil {
+		t.Fatalf("slow but talkative load failed: %v", err)
+	}
+	b.Close()
+
+	b = &workerBackend{script: []byte("import time\ntime.sleep(60)\n")}
+	start := time.Now()
+	err := b.Load(LoadOptions{Model: "tiny"})
+	b.Close()
+	if err == nil || !strings.Contains(err.Error(), "hung") {
+		t.Fatalf("got %v, want a hung worker error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s to give up", elapsed)
+	}
+}
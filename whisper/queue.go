@@ -0,0 +1,367 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// JobStatus is where a queued job stands.
+type JobStatus int
+
+const (
+	JobPending JobStatus = iota
+	JobRunning
+	JobDone
+	JobFailed
+	JobCancelled
+)
+
+func (s JobStatus) String() string {
+	switch s {
+	case JobPending:
+		return "pending"
+	case JobRunning:
+		return "running"
+	case JobDone:
+		return "done"
+	case JobFailed:
+		return "failed"
+	default:
+		return "cancelled"
+	}
+}
+
+// Finished reports whether the job has stopped for good, unless retried.
+func (s JobStatus) Finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCancelled
+}
+
+// Priority orders pending jobs; among equal priorities the older job runs first.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+// Job is a transcription waiting in, or processed by, a Queue. The queue hands out
+// copies, so a Job describes the moment it was taken.
+type Job struct {
+	ID       int
+	Name     string // shown in the queue, e.g. the recording time or the imported file's name
+	Request  Request
+	Priority Priority
+	// Temporary marks Request.AudioFile as owned by the queue, which deletes it when the
+	// job is removed or the queue is closed
+	Temporary bool
+
+	Status   JobStatus
+	Progress float64 // fraction decoded while running
+	Result   Result
+	Err      error
+	Added    time.Time
+	Started  time.Time
+	Finished time.Time
+}
+
+// Queue runs transcription jobs one at a time on the active backend, in priority order,
+// so new work can be submitted while earlier jobs are still being decoded. While no
+// backend is active, such as during the first model load, jobs wait.
+type Queue struct {
+	ctx      context.Context
+	onChange func(Job)
+
+	mu     sync.Mutex
+	jobs   []*Job
+	nextID int
+	cancel context.CancelFunc // cancels the running job
+	wake   chan struct{}
+	closed bool
+	done   chan struct{}
+}
+
+// NewQueue starts a queue that processes jobs until ctx is done or Close is called.
+// onChange, if not nil, is called from the queue's goroutine with a copy of every job
+// that is added, changes status or makes progress.
+func NewQueue(ctx context.Context, onChange func(Job)) *Queue {
+	q := &Queue{
+		ctx:      ctx,
+		onChange: onChange,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Add queues a job and returns its id. Only Name, Request, Priority and Temporary are used.
+func (q *Queue) Add(job Job) (int, error) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return 0, errors.New("queue is closed")
+	}
+	q.nextID++
+	j := &Job{
+		ID:        q.nextID,
+		Name:      job.Name,
+		Request:   job.Request,
+		Priority:  job.Priority,
+		Temporary: job.Temporary,
+		Status:    JobPending,
+		Added:     time.Now(),
+	}
+	q.jobs = append(q.jobs, j)
+	snapshot := *j
+	q.mu.Unlock()
+
+	q.notify(snapshot)
+	q.poke()
+	return j.ID, nil
+}
+
+// Jobs returns every job still in the queue, in the order they were added.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]Job, len(q.jobs))
+	for i, j := range q.jobs {
+		jobs[i] = *j
+	}
+	return jobs
+}
+
+// Job returns the job with the given id.
+func (q *Queue) Job(id int) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if j := q.findLocked(id); j != nil {
+		return *j, true
+	}
+	return Job{}, false
+}
+
+// Retry puts a failed or cancelled job back in the queue.
+func (q *Queue) Retry(id int) error {
+	q.mu.Lock()
+	j := q.findLocked(id)
+	if j == nil {
+		q.mu.Unlock()
+		return fmt.Errorf("no job %d", id)
+	}
+	if j.Status != JobFailed && j.Status != JobCancelled {
+		q.mu.Unlock()
+		return fmt.Errorf("job %d is %s", id, j.Status)
+	}
+	j.Status, j.Err, j.Progress = JobPending, nil, 0
+	j.Started, j.Finished = time.Time{}, time.Time{}
+	snapshot := *j
+	q.mu.Unlock()
+
+	q.notify(snapshot)
+	q.poke()
+	return nil
+}
+
+// Cancel stops a job: a pending job is skipped, a running one interrupted.
+func (q *Queue) Cancel(id int) {
+	q.mu.Lock()
+	j := q.findLocked(id)
+	if j == nil || j.Status.Finished() {
+		q.mu.Unlock()
+		return
+	}
+	if j.Status == JobRunning {
+		// run records the outcome once the backend has stopped
+		q.cancel()
+		q.mu.Unlock()
+		return
+	}
+	j.Status, j.Finished = JobCancelled, time.Now()
+	snapshot := *j
+	q.mu.Unlock()
+
+	q.notify(snapshot)
+}
+
+// Remove drops a job that is not running from the queue.
+func (q *Queue) Remove(id int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := slices.IndexFunc(q.jobs, func(j *Job) bool { return j.ID == id })
+	if i < 0 {
+		return fmt.Errorf("no job %d", id)
+	}
+	if q.jobs[i].Status == JobRunning {
+		return fmt.Errorf("job %d is running", id)
+	}
+	removeJobFile(q.jobs[i])
+	q.jobs = slices.Delete(q.jobs, i, i+1)
+	return nil
+}
+
+// ClearFinished removes every job that is done, and returns how many were removed.
+// Failed and cancelled jobs stay so they can be retried.
+func (q *Queue) ClearFinished() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := len(q.jobs)
+	q.jobs = slices.DeleteFunc(q.jobs, func(j *Job) bool {
+		if j.Status == JobDone {
+			removeJobFile(j)
+			return true
+		}
+		return false
+	})
+	return n - len(q.jobs)
+}
+
+// Close cancels the running job, waits for it to stop and deletes temporary files.
+// Jobs still pending are dropped.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.mu.Unlock()
+
+	q.poke()
+	<-q.done
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		removeJobFile(j)
+	}
+	q.jobs = nil
+}
+
+func (q *Queue) findLocked(id int) *Job {
+	for _, j := range q.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// nextLocked picks the pending job to run next: highest priority, then oldest.
+func (q *Queue) nextLocked() *Job {
+	var next *Job
+	for _, j := range q.jobs {
+		if j.Status != JobPending {
+			continue
+		}
+		if next == nil || j.Priority > next.Priority {
+			next = j
+		}
+	}
+	return next
+}
+
+func (q *Queue) poke() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *Queue) notify(j Job) {
+	if q.onChange != nil {
+		q.onChange(j)
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		j := q.nextLocked()
+		if j == nil {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+			case <-q.ctx.Done():
+				return
+			}
+			continue
+		}
+		if ok, changed := activeState(); !ok {
+			q.mu.Unlock()
+			select {
+			case <-changed:
+			case <-q.wake:
+			case <-q.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(q.ctx)
+		q.cancel = cancel
+		j.Status, j.Started = JobRunning, time.Now()
+		req := j.Request
+		snapshot := *j
+		q.mu.Unlock()
+		q.notify(snapshot)
+
+		onProgress := req.OnProgress
+		req.OnProgress = func(p Progress) {
+			q.mu.Lock()
+			j.Progress = p.Fraction()
+			snapshot := *j
+			q.mu.Unlock()
+			q.notify(snapshot)
+			if onProgress != nil {
+				onProgress(p)
+			}
+		}
+		result, err := TranscribeContext(ctx, req)
+		interrupted := ctx.Err() != nil
+		cancel()
+
+		q.mu.Lock()
+		q.cancel = nil
+		j.Finished = time.Now()
+		switch {
+		case err == nil:
+			j.Status, j.Result, j.Progress = JobDone, result, 1
+		case errors.Is(err, context.Canceled) || interrupted:
+			j.Status = JobCancelled
+		case errors.Is(err, errNotInitialized):
+			// The backend was unloaded just before the job reached it; wait for the next one
+			j.Status, j.Started, j.Finished = JobPending, time.Time{}, time.Time{}
+		default:
+			j.Status, j.Err = JobFailed, err
+		}
+		snapshot = *j
+		q.mu.Unlock()
+		q.notify(snapshot)
+	}
+}
+
+func removeJobFile(j *Job) {
+	if j.Temporary && j.Request.AudioFile != "" {
+		os.Remove(j.Request.AudioFile)
+	}
+}
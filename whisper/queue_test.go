@@ -0,0 +1,253 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queueBackend records the files it is asked to transcribe. With hold set, each request
+// waits for a value on release, or for its context.
+type queueBackend struct {
+	hold    bool
+	release chan struct{}
+
+	mu   sync.Mutex
+	seen []string
+	fail map[string]bool // files whose transcription fails
+}
+
+func (b *queueBackend) Load(LoadOptions) error { return nil }
+
+func (b *queueBackend) Transcribe(ctx context.Context, r Request) (Result, error) {
+	b.mu.Lock()
+	b.seen = append(b.seen, r.AudioFile)
+	fail := b.fail[r.AudioFile]
+	b.mu.Unlock()
+
+	if b.hold {
+		select {
+		case <-b.release:
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		}
+	}
+	if fail {
+		return Result{}, errors.New("model crashed")
+	}
+	return Result{Text: "text of " + r.AudioFile}, nil
+}
+
+func (b *queueBackend) setFail(file string, fail bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail[file] = fail
+}
+
+func (b *queueBackend) transcribed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.seen)
+}
+
+func (b *queueBackend) Capabilities() Capabilities { return Capabilities{} }
+func (b *queueBackend) Info() BackendInfo          { return BackendInfo{} }
+func (b *queueBackend) Close()                     {}
+
+var queueStub *queueBackend
+
+func init() {
+	Register("test-queue", func() Backend { return queueStub })
+}
+
+// newTestQueue makes a fresh stub the active backend and starts a queue on it.
+func newTestQueue(t *testing.T, hold bool) (*Queue, *queueBackend) {
+	t.Helper()
+	queueStub = &queueBackend{hold: hold, release: make(chan struct{}), fail: map[string]bool{}}
+	if err := Init("test-queue", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	q := NewQueue(context.Background(), nil)
+	t.Cleanup(func() {
+		q.Close()
+		Close()
+	})
+	return q, queueStub
+}
+
+func add(t *testing.T, q *Queue, file string, priority Priority) int {
+	t.Helper()
+	id, err := q.Add(Job{Name: file, Request: Request{AudioFile: file}, Priority: priority})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// waitStatus waits for job id to reach status.
+func waitStatus(t *testing.T, q *Queue, id int, status JobStatus) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, ok := q.Job(id)
+		if ok && j.Status == status {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d is %v, want %v", id, j.Status, status)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q, b := newTestQueue(t, true)
+
+	// The first job runs at once and holds the backend while the rest queue up
+	first := add(t, q, "first", PriorityNormal)
+	waitStatus(t, q, first, JobRunning)
+	ids := []int{
+		add(t, q, "low", PriorityLow),
+		add(t, q, "normal 1", PriorityNormal),
+		add(t, q, "high", PriorityHigh),
+		add(t, q, "normal 2", PriorityNormal),
+	}
+	for range 5 {
+		b.release <- struct{}{}
+	}
+	for _, id := range ids {
+		if j := waitStatus(t, q, id, JobDone); j.Result.Text != "text of "+j.Name {
+			t.Errorf("job %q has result %q", j.Name, j.Result.Text)
+		}
+	}
+
+	want := []string{"first", "high", "normal 1", "normal 2", "low"}
+	if got := b.transcribed(); !slices.Equal(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		running bool
+	}{
+		{"pending", false},
+		{"running", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, b := newTestQueue(t, true)
+			running := add(t, q, "running", PriorityNormal)
+			waitStatus(t, q, running, JobRunning)
+			pending := add(t, q, "pending", PriorityNormal)
+
+			target := pending
+			if tt.running {
+				target = running
+			}
+			q.Cancel(target)
+			waitStatus(t, q, target, JobCancelled)
+
+			// The queue carries on with the other job
+			if !tt.running {
+				b.release <- struct{}{}
+				waitStatus(t, q, running, JobDone)
+				if got := b.transcribed(); slices.Contains(got, "pending") {
+					t.Errorf("cancelled pending job was transcribed: %q", got)
+				}
+			} else {
+				waitStatus(t, q, pending, JobRunning)
+				b.release <- struct{}{}
+				waitStatus(t, q, pending, JobDone)
+			}
+
+			// Cancelling a finished job changes nothing
+			q.Cancel(target)
+			if j, _ := q.Job(target); j.Status != JobCancelled {
+				t.Errorf("job is %v after a second cancel", j.Status)
+			}
+		})
+	}
+}
+
+func TestQueueRetry(t *testing.T) {
+	q, b := newTestQueue(t, false)
+	b.setFail("take", true)
+
+	id := add(t, q, "take", PriorityNormal)
+	j := waitStatus(t, q, id, JobFailed)
+	if j.Err == nil || j.Err.Error() != "model crashed" {
+		t.Errorf("failed with %v", j.Err)
+	}
+	if err := q.Retry(id + 1); err == nil {
+		t.Error("retried a job that does not exist")
+	}
+
+	b.setFail("take", false)
+	if err := q.Retry(id); err != nil {
+		t.Fatal(err)
+	}
+	j = waitStatus(t, q, id, JobDone)
+	if j.Err != nil || j.Result.Text != "text of take" {
+		t.Errorf("retried job %+v", j)
+	}
+	if err := q.Retry(id); err == nil {
+		t.Error("retried a job that is done")
+	}
+}
+
+func TestQueueCloseRemovesTemporaryFiles(t *testing.T) {
+	q, _ := newTestQueue(t, true)
+	dir := t.TempDir()
+	files := map[string]bool{} // path: temporary
+	for _, name := range []string{"running.wav", "pending.wav", "imported.wav"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files[path] = name != "imported.wav"
+	}
+
+	running, _ := q.Add(Job{Request: Request{AudioFile: filepath.Join(dir, "running.wav")}, Temporary: true})
+	waitStatus(t, q, running, JobRunning)
+	q.Add(Job{Request: Request{AudioFile: filepath.Join(dir, "pending.wav")}, Temporary: true})
+	q.Add(Job{Request: Request{AudioFile: filepath.Join(dir, "imported.wav")}})
+
+	q.Close()
+	for path, temporary := range files {
+		if _, err := os.Stat(path); temporary != os.IsNotExist(err) {
+			t.Errorf("%s: temporary %v, stat %v", filepath.Base(path), temporary, err)
+		}
+	}
+	if len(q.Jobs()) != 0 {
+		t.Errorf("%d jobs left after Close", len(q.Jobs()))
+	}
+	if _, err := q.Add(Job{Request: Request{AudioFile: "late.wav"}}); err == nil {
+		t.Error("added a job to a closed queue")
+	}
+}
+
+func TestQueueWaitsForBackend(t *testing.T) {
+	q, b := newTestQueue(t, false)
+	Close() // as during the first load, or an exclusive model switch
+
+	id := add(t, q, "take", PriorityNormal)
+	time.Sleep(50 * time.Millisecond)
+	if j, _ := q.Job(id); j.Status != JobPending {
+		t.Fatalf("job is %v with no backend, want it pending", j.Status)
+	}
+
+	if err := Init("test-queue", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, q, id, JobDone)
+	if got := b.transcribed(); !slices.Equal(got, []string{"take"}) {
+		t.Errorf("ran %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+package whisper
+
+// Result is the outcome of a transcription job.
+type Result struct {
+	Text     string    // full transcript, including any requested markers
+	Segments []Segment // timed spans of the transcript, in order
+	Task     Task      // whether Text is a transcript or an English translation
+	Language string    // language code the backend reported, if any
+	Duration float64   // length of the transcribed audio in seconds
+
+	// LanguageProbability is the confidence of language detection, or 0 if the
+	// language was given in the request or the backend does not report it.
+	LanguageProbability float64
+}
+
+// Progress reports how far a transcription job has got.
+type Progress struct {
+	Processed float64   // seconds of audio decoded so far
+	Duration  float64   // total seconds of audio
+	Segments  []Segment // segments decoded so far, if the backend reports them before finishing
+}
+
+// Fraction returns the completed share of the job, from 0 to 1.
+func (p Progress) Fraction() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return min(p.Processed/p.Duration, 1)
+}
+
+// Segment is a timed span of transcribed text. The JSON form matches the segments of
+// the worker protocol and of OpenAI-style verbose_json responses.
+type Segment struct {
+	ID           int     `json:"id"`
+	Start        float64 `json:"start"`
+	End          float64 `json:"end"`
+	Text         string  `json:"text"`
+	AvgLogprob   float64 `json:"avg_logprob"`
+	NoSpeechProb float64 `json:"no_speech_prob"`
+	Words        []Word  `json:"words,omitempty"` // only filled when word timestamps were requested
+
+	// Provisional marks a segment from TranscribeStream that may still change
+	Provisional bool `json:"-"`
+}
+
+// Word is a single word with its timing and the model's confidence in it.
+type Word struct {
+	Word        string  `json:"word"`
+	Start       float64 `json:"start"`
+	End         float64 `json:"end"`
+	Probability float64 `json:"probability"`
+}
+
+// assignWords distributes a flat word list into the segments they fall within,
+// for servers that report words separately from segments.
+func assignWords(segments []Segment, words []Word) {
+	i := 0
+	for _, w := range words {
+		// Words belong to the last segment starting at or before them
+		for i+1 < len(segments) && segments[i+1].Start <= w.Start {
+			i++
+		}
+		if i < len(segments) {
+			segments[i].Words = append(segments[i].Words, w)
+		}
+	}
+}
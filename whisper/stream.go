@@ -0,0 +1,362 @@
+package whisper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// Sliding window for TranscribeStream, in seconds of audio.
+const (
+	streamStep      = 1.0  // new audio that triggers another pass
+	streamMaxWindow = 20.0 // once the window is this long, settled segments are made final
+	streamHold      = 5.0  // segments ending this close to the live edge stay provisional
+	streamForce     = 28.0 // Whisper sees 30 s at a time; past this the window is committed regardless
+	streamOverlap   = 1.0  // audio kept before the committed point, as context for the next pass
+
+	streamPromptChars = 200 // committed text passed on as the initial prompt
+	maxRepeatWords    = 8   // longest run of words recognised as transcribed twice
+)
+
+// TranscribeStream transcribes live audio: mono samples at SampleRate, in chunks of any
+// size, until samples is closed or ctx is done.
+//
+// The audio is decoded in passes over a window that slides along the stream. Each pass
+// sends the segments past the last final one with Provisional set; they replace the
+// provisional segments sent before. Once a segment is far enough from the live edge to
+// be settled it is sent again as final, and the window moves on, keeping a little audio
+// before that point as context. Text the overlap causes to be transcribed twice is dropped.
+// Segment times are from the start of the stream.
+//
+// Workers that report OptionStream keep the window themselves: each pass sends them
+// only the audio that arrived since the last, and they return the final and provisional
+// segments. For other backends the window is kept here and each pass is an ordinary
+// request carrying the window's samples. Either way a new pass starts once the last one
+// is answered and a step of audio has arrived, so a slow backend never falls behind.
+//
+// A pass that fails is reported on stderr and retried with more audio. Once the window
+// is full its audio is given up on, so a backend that keeps failing cannot make the
+// stream hold ever more audio. The returned channel is closed after the remaining audio
+// has been finalised.
+func TranscribeStream(ctx context.Context, samples <-chan []float32) (<-chan Segment, error) {
+	initMu.Lock()
+	b := active
+	initMu.Unlock()
+	if b == nil {
+		return nil, errNotInitialized
+	}
+
+	s := &stream{
+		ctx:  ctx,
+		out:  make(chan Segment, 16),
+		more: make(chan struct{}, 1),
+	}
+	if _, ok := b.Backend.(streamer); ok && slices.Contains(b.Info().Options, OptionStream) {
+		s.id = fmt.Sprintf("stream-%d", streamIDs.Add(1))
+	}
+	go s.read(samples)
+	go s.run()
+	return s.out, nil
+}
+
+// streamer is implemented by backends whose worker can keep the sliding window, when
+// it reports OptionStream. frame names the stream and says where samples, the audio
+// since the last pass, begin; the segments returned are in stream time.
+type streamer interface {
+	streamPass(ctx context.Context, frame streamFrame, samples []float32) (final, provisional []Segment, err error)
+}
+
+var streamIDs atomic.Int64
+
+type stream struct {
+	ctx context.Context
+	out chan Segment
+	id  string // the worker's name for the stream if it keeps the window, else empty
+
+	mu      sync.Mutex
+	samples []float32 // audio from offset on
+	offset  int       // stream position of samples[0]
+	closed  bool      // the input channel was closed
+	more    chan struct{}
+
+	// Owned by run; the window fields are unused when the worker keeps the window
+	windowStart  int     // stream position the next pass starts at
+	passEnd      int     // stream position the last pass ended at
+	committedEnd float64 // end of the last final segment, in seconds
+	committed    string  // final text so far
+	nextID       int
+}
+
+// read collects the input so a slow pass never blocks the producer.
+func (s *stream) read(samples <-chan []float32) {
+	for {
+		select {
+		case chunk, ok := <-samples:
+			s.mu.Lock()
+			if ok {
+				s.samples = append(s.samples, chunk...)
+			} else {
+				s.closed = true
+			}
+			s.mu.Unlock()
+
+			select {
+			case s.more <- struct{}{}:
+			default:
+			}
+			if !ok {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *stream) run() {
+	defer close(s.out)
+	defer func() {
+		if s.id != "" && s.ctx.Err() != nil {
+			go s.drop()
+		}
+	}()
+
+	for {
+		closed, ok := s.wait()
+		if !ok {
+			return
+		}
+		pass := s.pass
+		if s.id != "" {
+			pass = s.remotePass
+		}
+		if err := pass(closed); err != nil && s.ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "stream: transcription pass failed: %v\n", err)
+		}
+		if closed || s.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// wait blocks until a step of new audio has arrived or the input is closed. It returns
+// false if ctx is done.
+func (s *stream) wait() (closed bool, ok bool) {
+	for {
+		s.mu.Lock()
+		end, closed := s.offset+len(s.samples), s.closed
+		s.mu.Unlock()
+
+		if closed || float64(end-s.passEnd) >= streamStep*SampleRate {
+			return closed, true
+		}
+		select {
+		case <-s.more:
+		case <-s.ctx.Done():
+			return false, false
+		}
+	}
+}
+
+// pass transcribes the current window, sending final and provisional segments. With
+// last set everything is made final.
+func (s *stream) pass(last bool) error {
+	s.mu.Lock()
+	window := slices.Clone(s.samples[s.windowStart-s.offset:])
+	s.mu.Unlock()
+	if len(window) == 0 {
+		return nil
+	}
+	s.passEnd = s.windowStart + len(window)
+
+	base := float64(s.windowStart) / SampleRate
+	length := float64(len(window)) / SampleRate
+
+	result, err := TranscribeContext(s.ctx, Request{
+		Samples:       window,
+		InitialPrompt: tail(s.committed, streamPromptChars),
+	})
+	if err != nil {
+		if length >= streamForce {
+			s.advance(s.passEnd - int(streamOverlap*SampleRate))
+		}
+		return err
+	}
+	segments := s.fresh(result.Segments, base)
+
+	var commit int
+	switch {
+	case last || length >= streamForce:
+		commit = len(segments)
+	case length >= streamMaxWindow:
+		edge := base + length - streamHold
+		for commit < len(segments) && segments[commit].End <= edge {
+			commit++
+		}
+	}
+
+	for _, seg := range segments[:commit] {
+		seg.ID = s.nextID
+		s.nextID++
+		if !s.send(seg) {
+			return nil
+		}
+		s.committedEnd = seg.End
+		s.committed = strings.TrimSpace(s.committed + " " + seg.Text)
+	}
+	for i, seg := range segments[commit:] {
+		seg.ID = s.nextID + i
+		seg.Provisional = true
+		if !s.send(seg) {
+			return nil
+		}
+	}
+
+	// Move the window past what is final, or past the whole window if it is full
+	// but produced nothing final (silence, or speech Whisper could not make out)
+	next := int((s.committedEnd - streamOverlap) * SampleRate)
+	if length >= streamForce {
+		next = max(next, s.passEnd-int(streamOverlap*SampleRate))
+	}
+	s.advance(next)
+	return nil
+}
+
+// remotePass sends the audio since the last pass to the worker, which keeps the window,
+// and sends on the segments it returns. With last set the worker makes everything final.
+func (s *stream) remotePass(last bool) error {
+	s.mu.Lock()
+	chunk, offset := s.samples, s.offset
+	s.samples = nil
+	s.offset += len(chunk)
+	s.mu.Unlock()
+	s.passEnd = offset + len(chunk)
+
+	b, err := acquire()
+	if err != nil {
+		return err
+	}
+	defer b.running.Done()
+	st, ok := b.Backend.(streamer)
+	if !ok {
+		return errors.New("the active backend cannot keep a stream's window")
+	}
+	final, provisional, err := st.streamPass(s.ctx, streamFrame{ID: s.id, Offset: offset, End: last}, chunk)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range final {
+		seg.ID = s.nextID
+		s.nextID++
+		if !s.send(seg) {
+			return nil
+		}
+	}
+	for i, seg := range provisional {
+		seg.ID = s.nextID + i
+		seg.Provisional = true
+		if !s.send(seg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// drop tells the worker to forget an abandoned stream's window.
+func (s *stream) drop() {
+	b, err := acquire()
+	if err != nil {
+		return
+	}
+	defer b.running.Done()
+	if st, ok := b.Backend.(streamer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), cancelGrace)
+		defer cancel()
+		st.streamPass(ctx, streamFrame{ID: s.id, Drop: true}, nil)
+	}
+}
+
+// advance starts the next window at stream position next, dropping the audio before it.
+func (s *stream) advance(next int) {
+	if next <= s.windowStart {
+		return
+	}
+	s.windowStart = next
+	s.mu.Lock()
+	s.samples = s.samples[next-s.offset:]
+	s.offset = next
+	s.mu.Unlock()
+}
+
+// fresh shifts segments to stream time and drops what was already made final: segments
+// ending inside the committed audio, and the words at the start of the first one that
+// repeat the end of the committed text.
+func (s *stream) fresh(segments []Segment, base float64) []Segment {
+	var out []Segment
+	for _, seg := range segments {
+		seg.Start += base
+		seg.End += base
+		seg.Words = nil // word times would need shifting too, and streams do not ask for them
+		if seg.End <= s.committedEnd {
+			continue
+		}
+		if len(out) == 0 && seg.Start < s.committedEnd+streamOverlap {
+			seg.Text = trimRepeat(s.committed, seg.Text)
+		}
+		if seg.Text = strings.TrimSpace(seg.Text); seg.Text != "" {
+			out = append(out, seg)
+		}
+	}
+	return out
+}
+
+func (s *stream) send(seg Segment) bool {
+	select {
+	case s.out <- seg:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// trimRepeat removes from the start of text the longest run of words that also ends
+// committed, ignoring case and punctuation.
+func trimRepeat(committed, text string) string {
+	prev := strings.Fields(committed)
+	words := strings.Fields(text)
+	for n := min(len(prev), len(words), maxRepeatWords); n > 0; n-- {
+		if slices.EqualFunc(prev[len(prev)-n:], words[:n], sameWord) {
+			return strings.Join(words[n:], " ")
+		}
+	}
+	return text
+}
+
+func sameWord(a, b string) bool {
+	return normalizeWord(a) == normalizeWord(b)
+}
+
+func normalizeWord(w string) string {
+	return strings.ToLower(strings.TrimFunc(w, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// tail returns about the last n bytes of s, starting at a word boundary.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[len(s)-n:]
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,440 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wordBackend stands in for a model: each second of audio holds a constant level k/1000
+// and is "heard" as the word wk. Segments are three words long and timed from the start
+// of the window, like Whisper's.
+type wordBackend struct {
+	fail   bool
+	passes chan struct{} // if set, signalled after each request
+}
+
+func (b *wordBackend) Load(LoadOptions) error { return nil }
+
+func (b *wordBackend) Transcribe(ctx context.Context, r Request) (Result, error) {
+	if b.passes != nil {
+		defer func() {
+			select {
+			case b.passes <- struct{}{}:
+			default:
+			}
+		}()
+	}
+	if b.fail {
+		return Result{}, errors.New("worker crashed")
+	}
+	seconds := (len(r.Samples) + SampleRate - 1) / SampleRate
+	var segments []Segment
+	for start := 0; start < seconds; start += 3 {
+		end := min(start+3, seconds)
+		var words []string
+		for i := start; i < end; i++ {
+			level := r.Samples[min(i*SampleRate+SampleRate/2, len(r.Samples)-1)]
+			words = append(words, fmt.Sprintf("w%d", int(level*1000+0.5)))
+		}
+		segments = append(segments, Segment{Start: float64(start), End: float64(end), Text: strings.Join(words, " ")})
+	}
+	return Result{Segments: segments}, nil
+}
+
+func (b *wordBackend) Capabilities() Capabilities { return Capabilities{} }
+func (b *wordBackend) Info() BackendInfo          { return BackendInfo{Options: []string{OptionPCM}} }
+func (b *wordBackend) Close()                     {}
+
+var streamBackend = &wordBackend{}
+
+func init() {
+	Register("test-words", func() Backend { return streamBackend })
+}
+
+// speak feeds n seconds of words to in, a quarter second at a time, then closes it. Like
+// a live source it does not get ahead of the transcription: after each second it waits
+// for the pass that second triggers.
+func speak(in chan<- []float32, n int, passes <-chan struct{}) {
+	for k := 0; k < n; k++ {
+		for range 4 {
+			chunk := make([]float32, SampleRate/4)
+			for i := range chunk {
+				chunk[i] = float32(k) / 1000
+			}
+			in <- chunk
+		}
+		<-passes
+	}
+	close(in)
+}
+
+func TestTranscribeStream(t *testing.T) {
+	if err := Init("test-words", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	streamBackend.passes = make(chan struct{}, 1)
+	t.Cleanup(func() { streamBackend.passes = nil })
+
+	const seconds = 70
+	in := make(chan []float32)
+	out, err := TranscribeStream(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go speak(in, seconds, streamBackend.passes)
+
+	var final, provisional []Segment
+	seenProvisional := map[string]bool{}
+	timeout := time.After(30 * time.Second)
+	for done := false; !done; {
+		select {
+		case seg, ok := <-out:
+			if !ok {
+				done = true
+				break
+			}
+			if seg.Provisional {
+				// Each batch replaces the last; a batch starts where the final text ends
+				if len(provisional) > 0 && seg.ID <= provisional[len(provisional)-1].ID {
+					provisional = nil
+				}
+				provisional = append(provisional, seg)
+				for _, w := range strings.Fields(seg.Text) {
+					seenProvisional[w] = true
+				}
+				continue
+			}
+			provisional = nil
+			// Only the context kept before the committed point may be heard again
+			if len(final) > 0 && seg.Start < final[len(final)-1].End-streamOverlap {
+				t.Errorf("final segment %+v overlaps %+v", seg, final[len(final)-1])
+			}
+			final = append(final, seg)
+		case <-timeout:
+			t.Fatal("output not closed after the input was")
+		}
+	}
+
+	var got, want []string
+	for _, seg := range final {
+		got = append(got, seg.Text)
+	}
+	for k := range seconds {
+		want = append(want, fmt.Sprintf("w%d", k))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("final text\n%s\nwant\n%s", strings.Join(got, " "), strings.Join(want, " "))
+	}
+	if len(provisional) != 0 {
+		t.Errorf("provisional segments %+v left after the last final one", provisional)
+	}
+	// Words are shown provisionally before they are settled, except the ones that only
+	// arrived in the final pass
+	if !seenProvisional["w0"] || !seenProvisional["w30"] {
+		t.Errorf("words were not sent provisionally first")
+	}
+	for i, seg := range final {
+		if seg.ID != i {
+			t.Errorf("final segment %d has id %d", i, seg.ID)
+		}
+	}
+}
+
+func TestTranscribeStreamCancelled(t *testing.T) {
+	if err := Init("test-words", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan []float32)
+	out, err := TranscribeStream(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-out:
+		for range out {
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("output not closed after cancellation")
+	}
+}
+
+// pipeBackend is a workerBackend talking to a fakeWorker, ready from the start.
+type pipeBackend struct{ *workerBackend }
+
+func (pipeBackend) Load(LoadOptions) error { return nil }
+func (pipeBackend) Close()                 {}
+
+var pipeWorker *workerBackend
+
+func init() {
+	Register("test-pipe", func() Backend { return pipeBackend{pipeWorker} })
+}
+
+// newPipeWorker makes a worker that keeps stream windows the active backend, and returns
+// its far end.
+func newPipeWorker(t *testing.T) *fakeWorker {
+	t.Helper()
+	w := newFakeWorker(t)
+	pipeWorker = &workerBackend{
+		sup:  &supervisor{state: WorkerReady, changed: make(chan struct{}), exited: make(chan struct{})},
+		conn: w.conn,
+		info: BackendInfo{Options: []string{OptionPCM, OptionStream}},
+	}
+	if err := Init("test-pipe", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	return w
+}
+
+// reply answers a stream pass.
+func (w *fakeWorker) reply(t *testing.T, id int64, final, provisional []Segment) {
+	t.Helper()
+	line, err := json.Marshal(workerMessage{Type: msgResult, ID: id, Segments: final, Provisional: provisional})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.write(t, string(line))
+}
+
+// second is a second of audio at level k/1000, heard by wordBackend as wk.
+func second(k int) []float32 {
+	out := make([]float32, SampleRate)
+	for i := range out {
+		out[i] = float32(k) / 1000
+	}
+	return out
+}
+
+// expect reads the next segment from out and compares it with want.
+func expect(t *testing.T, out <-chan Segment, want Segment) {
+	t.Helper()
+	select {
+	case seg, ok := <-out:
+		if !ok {
+			t.Fatalf("output closed, want %+v", want)
+		}
+		if seg.ID != want.ID || seg.Text != want.Text || seg.Start != want.Start || seg.End != want.End || seg.Provisional != want.Provisional {
+			t.Fatalf("got segment %+v, want %+v", seg, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no segment, want %+v", want)
+	}
+}
+
+func expectClosed(t *testing.T, out <-chan Segment) {
+	t.Helper()
+	select {
+	case seg, ok := <-out:
+		if ok {
+			t.Fatalf("got segment %+v, want the output closed", seg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("output not closed")
+	}
+}
+
+func TestTranscribeStreamWorker(t *testing.T) {
+	w := newPipeWorker(t)
+	in := make(chan []float32)
+	out, err := TranscribeStream(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in <- second(0)
+	req := w.request(t)
+	if req.Stream == nil || req.Stream.ID == "" || req.Stream.Offset != 0 || req.Stream.End {
+		t.Fatalf("first pass %+v, stream %+v", req, req.Stream)
+	}
+	if got := w.samples(t, req); len(got) != SampleRate {
+		t.Fatalf("first pass sent %d samples", len(got))
+	}
+	id := req.Stream.ID
+
+	// Audio that arrives during a pass waits for it, and then goes in one piece
+	in <- append(second(1), second(2)...)
+	w.reply(t, req.ID, nil, []Segment{{Start: 0, End: 1, Text: "w0"}})
+	expect(t, out, Segment{ID: 0, Start: 0, End: 1, Text: "w0", Provisional: true})
+
+	req = w.request(t)
+	if req.Stream == nil || req.Stream.ID != id || req.Stream.Offset != SampleRate || req.Stream.End {
+		t.Fatalf("second pass %+v, stream %+v", req, req.Stream)
+	}
+	if got := w.samples(t, req); len(got) != 2*SampleRate || got[0] != 0.001 || got[SampleRate] != 0.002 {
+		t.Fatalf("second pass sent %d samples", len(got))
+	}
+	w.reply(t, req.ID, []Segment{{Start: 0, End: 1, Text: "w0"}}, []Segment{{Start: 1, End: 3, Text: "w1 w2"}})
+	expect(t, out, Segment{ID: 0, Start: 0, End: 1, Text: "w0"})
+	expect(t, out, Segment{ID: 1, Start: 1, End: 3, Text: "w1 w2", Provisional: true})
+
+	// Closing the input asks the worker to make the rest final
+	close(in)
+	req = w.request(t)
+	if req.Stream == nil || req.Stream.ID != id || req.Stream.Offset != 3*SampleRate || !req.Stream.End || req.PCM != nil {
+		t.Fatalf("last pass %+v, stream %+v", req, req.Stream)
+	}
+	w.reply(t, req.ID, []Segment{{Start: 1, End: 3, Text: "w1 w2"}}, nil)
+	expect(t, out, Segment{ID: 1, Start: 1, End: 3, Text: "w1 w2"})
+	expectClosed(t, out)
+}
+
+func TestTranscribeStreamWorkerCancelled(t *testing.T) {
+	w := newPipeWorker(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan []float32)
+	out, err := TranscribeStream(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in <- second(0)
+	req := w.request(t)
+	w.samples(t, req)
+	w.reply(t, req.ID, nil, []Segment{{Start: 0, End: 1, Text: "w0"}})
+	expect(t, out, Segment{ID: 0, Start: 0, End: 1, Text: "w0", Provisional: true})
+
+	// The worker is told to forget the window
+	cancel()
+	expectClosed(t, out)
+	drop := w.request(t)
+	if drop.Stream == nil || drop.Stream.ID != req.Stream.ID || !drop.Stream.Drop || drop.PCM != nil {
+		t.Fatalf("got %+v, stream %+v; want the window dropped", drop, drop.Stream)
+	}
+	w.reply(t, drop.ID, nil, nil)
+}
+
+// wordWorker hears words like wordBackend, but keeps the stream window in
+// worker_protocol.py.
+var wordWorker = []byte(`from worker_protocol import ready, serve
+
+ready(model="tiny", device="cpu", dtype="float32", versions={})
+
+def transcribe(req_id, req, audio):
+    seconds = (len(audio) + 15999) // 16000
+    segments = []
+    for start in range(0, seconds, 3):
+        end = min(start + 3, seconds)
+        words = ["w%d" % int(audio[min(i * 16000 + 8000, len(audio) - 1)] * 1000 + 0.5) for i in range(start, end)]
+        segments.append({"start": start, "end": end, "text": " ".join(words)})
+    return {"text": "", "segments": segments}
+
+serve(transcribe)
+`)
+
+func init() {
+	Register("test-word-worker", func() Backend { return &workerBackend{script: wordWorker} })
+}
+
+func TestWorkerStreamWindow(t *testing.T) {
+	if err := exec.Command("python3", "-c", "import numpy").Run(); err != nil {
+		t.Skip("python3 with numpy not found")
+	}
+	t.Setenv("PYTHON_ENV", "")
+	if err := Init("test-word-worker", LoadOptions{Model: "tiny"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+
+	const seconds = 70
+	in := make(chan []float32)
+	out, err := TranscribeStream(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for k := range seconds {
+			in <- second(k)
+			time.Sleep(20 * time.Millisecond)
+		}
+		close(in)
+	}()
+
+	var got, want []string
+	timeout := time.After(60 * time.Second)
+	for done := false; !done; {
+		select {
+		case seg, ok := <-out:
+			if !ok {
+				done = true
+			} else if !seg.Provisional {
+				if seg.ID != len(got) {
+					t.Errorf("final segment %d has id %d", len(got), seg.ID)
+				}
+				got = append(got, seg.Text)
+			}
+		case <-timeout:
+			t.Fatal("output not closed after the input was")
+		}
+	}
+	for k := range seconds {
+		want = append(want, fmt.Sprintf("w%d", k))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("final text\n%s\nwant\n%s", strings.Join(got, " "), strings.Join(want, " "))
+	}
+	if len(got) < 3 {
+		t.Errorf("%d final segments; want the window to have slid", len(got))
+	}
+}
+
+func TestStreamDropsAudioWhenPassesFail(t *testing.T) {
+	if err := Init("test-words", LoadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	streamBackend.fail = true
+	t.Cleanup(func() { streamBackend.fail = false })
+
+	s := &stream{ctx: context.Background(), out: make(chan Segment, 16), more: make(chan struct{}, 1)}
+	s.samples = make([]float32, 40*SampleRate)
+
+	if err := s.pass(false); err == nil {
+		t.Fatal("pass succeeded on a failing backend")
+	}
+	if kept := len(s.samples); kept > streamOverlap*SampleRate {
+		t.Errorf("kept %d samples of a full window that failed, want at most the overlap", kept)
+	}
+	if s.windowStart != s.offset {
+		t.Errorf("window starts at %d, audio at %d", s.windowStart, s.offset)
+	}
+
+	// A failure on a window that is not full keeps the audio for the next try
+	s = &stream{ctx: context.Background(), out: make(chan Segment, 16), more: make(chan struct{}, 1)}
+	s.samples = make([]float32, 5*SampleRate)
+	s.pass(false)
+	if len(s.samples) != 5*SampleRate {
+		t.Errorf("kept %d samples, want all of them", len(s.samples))
+	}
+}
+
+func TestTrimRepeat(t *testing.T) {
+	tests := []struct {
+		committed, text, want string
+	}{
+		{"", "hello world", "hello world"},
+		{"the quick brown fox", "brown fox jumps over", "jumps over"},
+		{"The quick brown fox.", "fox, jumps", "jumps"},
+		{"one two three", "four five", "four five"},
+		{"say it again", "say it again", ""},
+		// Only the end of the committed text counts
+		{"fox jumps over the dog", "fox jumps", "fox jumps"},
+	}
+	for _, tt := range tests {
+		if got := trimRepeat(tt.committed, tt.text); got != tt.want {
+			t.Errorf("trimRepeat(%q, %q) = %q, want %q", tt.committed, tt.text, got, tt.want)
+		}
+	}
+}
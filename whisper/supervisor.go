@@ -0,0 +1,343 @@
+package whisper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// WorkerState is the health of the process behind the active backend.
+type WorkerState int
+
+const (
+	WorkerStarting   WorkerState = iota
+	WorkerReady                  // the model is loaded and requests are accepted
+	WorkerRestarting             // the process exited or was stopped and is being started again
+	WorkerFailed                 // the process could not be restarted; the model must be loaded again
+	WorkerStopped                // the backend was closed
+)
+
+func (s WorkerState) String() string {
+	switch s {
+	case WorkerStarting:
+		return "starting"
+	case WorkerReady:
+		return "ready"
+	case WorkerRestarting:
+		return "restarting"
+	case WorkerFailed:
+		return "failed"
+	default:
+		return "stopped"
+	}
+}
+
+var (
+	stateListener func(WorkerState, string)
+	stateMu       sync.Mutex
+)
+
+// OnWorkerState sets the function told about changes in worker state, along with the
+// reason for restarts and failures. It is called from background goroutines.
+func OnWorkerState(fn func(state WorkerState, reason string)) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	stateListener = fn
+}
+
+func reportState(state WorkerState, reason string) {
+	stateMu.Lock()
+	fn := stateListener
+	stateMu.Unlock()
+	if fn != nil {
+		fn(state, reason)
+	}
+}
+
+const (
+	restartBackoffMin  = time.Second
+	restartBackoffMax  = 30 * time.Second
+	maxRestartAttempts = 5
+	// A process that stays up this long is considered healthy, and the backoff starts over
+	stableUptime = time.Minute
+	stopGrace    = 5 * time.Second
+)
+
+// supervisor keeps a worker process running. start launches a process and blocks until
+// it is ready; if the process exits without stop being called it is started again with
+// the same options, backing off exponentially and giving up after maxRestartAttempts.
+type supervisor struct {
+	start  func() (*exec.Cmd, error)
+	stderr stderrTail // fed by start, so exit reasons can quote the process's last words
+
+	mu       sync.Mutex
+	state    WorkerState
+	changed  chan struct{} // closed and replaced on every state change
+	cmd      *exec.Cmd
+	exited   chan struct{} // closed when cmd has exited
+	reason   string        // why the last process exited or failed to start
+	planned  string        // reason for a restart requested through restart
+	readyAt  time.Time
+	failures int
+}
+
+func newSupervisor(start func() (*exec.Cmd, error)) *supervisor {
+	return &supervisor{start: start, state: WorkerStarting, changed: make(chan struct{})}
+}
+
+// run starts the first process. Unlike a restart, a failure here is returned, not retried.
+func (s *supervisor) run() error {
+	reportState(WorkerStarting, "")
+	cmd, err := s.start()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.readyLocked(cmd)
+	s.mu.Unlock()
+	reportState(WorkerReady, "")
+	return nil
+}
+
+func (s *supervisor) setStateLocked(state WorkerState) {
+	s.state = state
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *supervisor) readyLocked(cmd *exec.Cmd) {
+	s.cmd = cmd
+	s.exited = make(chan struct{})
+	s.readyAt = time.Now()
+	s.setStateLocked(WorkerReady)
+	go s.watch(cmd, s.exited)
+}
+
+// watch waits for cmd to exit and restarts it unless the supervisor was stopped.
+func (s *supervisor) watch(cmd *exec.Cmd, exited chan struct{}) {
+	err := cmd.Wait()
+
+	s.mu.Lock()
+	reason := exitReason(cmd.ProcessState, err, s.stderr.String())
+	if s.planned != "" {
+		// Restarts we asked for are not the worker's fault
+		reason, s.planned = s.planned, ""
+		s.failures = 0
+	} else if time.Since(s.readyAt) >= stableUptime {
+		s.failures = 0
+	}
+	s.reason = reason
+	s.cmd = nil
+	close(exited)
+	if s.state == WorkerStopped {
+		s.mu.Unlock()
+		return
+	}
+	s.setStateLocked(WorkerRestarting)
+	s.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "worker exited (%s), restarting\n", reason)
+	reportState(WorkerRestarting, reason)
+	s.restartLoop()
+}
+
+func (s *supervisor) restartLoop() {
+	for {
+		s.mu.Lock()
+		if s.state == WorkerStopped {
+			s.mu.Unlock()
+			return
+		}
+		if s.failures >= maxRestartAttempts {
+			s.setStateLocked(WorkerFailed)
+			reason := s.reason
+			s.mu.Unlock()
+			reportState(WorkerFailed, reason)
+			return
+		}
+		delay := min(restartBackoffMin<<s.failures, restartBackoffMax)
+		s.failures++
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		if s.currentState() == WorkerStopped {
+			return
+		}
+
+		cmd, err := s.start()
+
+		s.mu.Lock()
+		if err != nil {
+			s.reason = err.Error()
+			s.mu.Unlock()
+			continue
+		}
+		if s.state == WorkerStopped {
+			// Closed while the model was loading
+			s.mu.Unlock()
+			cmd.Process.Kill()
+			cmd.Wait()
+			return
+		}
+		s.readyLocked(cmd)
+		s.mu.Unlock()
+		reportState(WorkerReady, "")
+		return
+	}
+}
+
+func (s *supervisor) currentState() WorkerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// waitReady blocks until the worker is ready, failing if it cannot be restarted.
+// It returns the channel closed when the ready process exits.
+func (s *supervisor) waitReady(ctx context.Context) (<-chan struct{}, error) {
+	for {
+		s.mu.Lock()
+		state, changed, exited, reason := s.state, s.changed, s.exited, s.reason
+		s.mu.Unlock()
+
+		switch state {
+		case WorkerReady:
+			return exited, nil
+		case WorkerFailed:
+			return nil, fmt.Errorf("worker could not be restarted: %s", reason)
+		case WorkerStopped:
+			return nil, errors.New("worker is not running")
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// lastExit returns why the most recent process exited.
+func (s *supervisor) lastExit() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+// restart kills the running process, giving reason as the cause; the watcher starts a new one.
+func (s *supervisor) restart(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd != nil {
+		s.planned = reason
+		s.cmd.Process.Kill()
+	}
+}
+
+// signal sends sig to the running process.
+func (s *supervisor) signal(sig os.Signal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		return errors.New("worker is not running")
+	}
+	return s.cmd.Process.Signal(sig)
+}
+
+// stop shuts the worker down for good. graceful asks the process to exit; it is killed
+// if it has not done so within stopGrace.
+func (s *supervisor) stop(graceful func()) {
+	s.mu.Lock()
+	if s.state == WorkerStopped {
+		s.mu.Unlock()
+		return
+	}
+	s.setStateLocked(WorkerStopped)
+	cmd, exited := s.cmd, s.exited
+	s.mu.Unlock()
+
+	if cmd == nil {
+		return
+	}
+	graceful()
+	select {
+	case <-exited:
+	case <-time.After(stopGrace):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// exitReason describes how a process ended, in words a user can act on.
+func exitReason(state *os.ProcessState, err error, lastLine string) string {
+	if state == nil {
+		if err != nil {
+			return err.Error()
+		}
+		return "unknown reason"
+	}
+
+	var reason string
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		reason = "terminated: " + ws.Signal().String()
+		// Nobody else sends SIGKILL to a worker; on Linux it is almost always the OOM killer
+		if ws.Signal() == syscall.SIGKILL {
+			reason += ", probably out of memory"
+		}
+	} else {
+		reason = fmt.Sprintf("exit code %d", state.ExitCode())
+	}
+
+	if lastLine != "" {
+		reason += ": " + lastLine
+	}
+	return reason
+}
+
+// stderrTail passes nothing on; it remembers the last non-empty line written to it,
+// which for a Python worker that crashed is the exception that killed it.
+type stderrTail struct {
+	mu      sync.Mutex
+	last    []byte
+	partial []byte
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(t.partial[:i]); len(line) > 0 {
+			t.last = append(t.last[:0], line...)
+		}
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *stderrTail) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last, t.partial = nil, nil
+}
+
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if line := bytes.TrimSpace(t.partial); len(line) > 0 {
+		return string(line)
+	}
+	return string(t.last)
+}
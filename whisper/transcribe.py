@@ -1,9 +1,83 @@
 #!/usr/bin/env python3
 
 import whisper
+import torch
 import sys
 import argparse
-import json
+import platform
+import types
+
+from worker_protocol import send, ready, serve
+
+def to_segment(seg):
+    return {
+        "id": seg["id"],
+        "start": seg["start"],
+        "end": seg["end"],
+        "text": seg["text"].strip(),
+        "avg_logprob": seg["avg_logprob"],
+        "no_speech_prob": seg["no_speech_prob"],
+        "words": [
+            {"word": w["word"], "start": w["start"], "end": w["end"], "probability": w["probability"]}
+            for w in seg.get("words", [])
+        ],
+    }
+
+def detect_language(model, audio):
+    """Return the most likely language of the first 30 seconds and its probability."""
+    mel = whisper.log_mel_spectrogram(whisper.pad_or_trim(audio), model.dims.n_mels).to(model.device)
+    _, probs = model.detect_language(mel)
+    language = max(probs, key=probs.get)
+    return language, probs[language]
+
+def decode_options(req):
+    """Translate the request's decode options into model.transcribe keyword arguments."""
+    opts = req.get("decode")
+    if not opts:
+        return {}
+    return {
+        # A beam of one is greedy decoding, which whisper selects with beam_size=None
+        "beam_size": opts["beam_size"] if opts["beam_size"] > 1 else None,
+        "best_of": opts["best_of"],
+        "temperature": tuple(opts["temperature"]),
+        "fp16": opts["fp16"],
+        "condition_on_previous_text": opts["condition_on_previous_text"],
+        "compression_ratio_threshold": opts["compression_ratio_threshold"],
+        "no_speech_threshold": opts["no_speech_threshold"],
+    }
+
+class ProgressReporter:
+    """Stands in for the tqdm progress bar inside whisper.transcribe, which counts mel
+    frames, and reports each step as a PROGRESS message for request_id instead, with the
+    segments decoded since the previous step."""
+
+    request_id = 0
+
+    def __init__(self, total=None, **kwargs):
+        self.total = total or 0
+        self.n = 0
+        self.sent = 0
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        return False
+
+    def update(self, n=1):
+        self.n += n
+        # transcribe() has no hook for segments, but keeps them in a local list that is
+        # extended just before each step; versions without it report no segments
+        decoded = sys._getframe(1).f_locals.get("all_segments", [])
+        new = decoded[self.sent:]
+        self.sent = len(decoded)
+        send({
+            "type": "PROGRESS",
+            "id": ProgressReporter.request_id,
+            "processed": self.n / whisper.audio.FRAMES_PER_SECOND,
+            "duration": self.total / whisper.audio.FRAMES_PER_SECOND,
+            "segments": [to_segment(seg) for seg in new],
+        })
 
 def main():
     parser = argparse.ArgumentParser(description='Transcribe audio using Whisper via IPC')
@@ -13,23 +87,54 @@ def main():
 
     try:
         model = whisper.load_model(args.model, device=args.device)
-        print(json.dumps({"status": "READY"}), flush=True)
+        ready(
+            model=args.model,
+            device=str(model.device),
+            # transcribe() decodes in half precision on GPUs unless fp16 is turned off
+            dtype="float16" if model.device.type == "cuda" else "float32",
+            versions={
+                "python": platform.python_version(),
+                "whisper": whisper.__version__,
+                "torch": torch.__version__,
+                "cuda": torch.version.cuda or "none",
+            },
+        )
     except Exception as e:
-        print(json.dumps({"status": "ERROR", "error": str(e)}), flush=True)
+        send({"type": "ERROR", "error": str(e)})
         sys.exit(1)
 
-    for line in sys.stdin:
-        try:
-            req = json.loads(line)
-            audio_file = req.get("audio_file")
-            if not audio_file:
-                print(json.dumps({"status": "ERROR", "error": "Missing audio_file in request"}), flush=True)
-                continue
-            
-            result = model.transcribe(audio_file)
-            print(json.dumps({"status": "SUCCESS", "text": result["text"].strip()}), flush=True)
-        except Exception as e:
-            print(json.dumps({"status": "ERROR", "error": str(e)}), flush=True)
+    # whisper.transcribe (the module, shadowed by the function of the same name) uses tqdm.tqdm
+    sys.modules["whisper.transcribe"].tqdm = types.SimpleNamespace(tqdm=ProgressReporter)
+
+    def transcribe(req_id, req, audio):
+        # Load the audio ourselves so the duration can be reported; files go through ffmpeg
+        if isinstance(audio, str):
+            audio = whisper.load_audio(audio)
+
+        # transcribe() does not report how sure it is of the language, so detect it here
+        language = req.get("language") or None
+        language_probability = None
+        if language is None:
+            language, language_probability = detect_language(model, audio)
+
+        ProgressReporter.request_id = req_id
+        result = model.transcribe(
+            audio,
+            task=req.get("task") or "transcribe",
+            language=language,
+            initial_prompt=req.get("initial_prompt") or None,
+            word_timestamps=bool(req.get("word_timestamps")),
+            **decode_options(req),
+        )
+        return {
+            "text": result["text"].strip(),
+            "segments": [to_segment(seg) for seg in result["segments"]],
+            "language": result["language"],
+            "language_probability": language_probability,
+            "duration": len(audio) / whisper.audio.SAMPLE_RATE,
+        }
+
+    serve(transcribe)
 
 if __name__ == "__main__":
     main()
@@ -0,0 +1,97 @@
+#!/usr/bin/env python3
+
+from faster_whisper import WhisperModel
+import faster_whisper
+import ctranslate2
+import sys
+import argparse
+import platform
+
+from worker_protocol import send, ready, serve
+
+def to_segment(seg):
+    return {
+        "id": seg.id,
+        "start": seg.start,
+        "end": seg.end,
+        "text": seg.text.strip(),
+        "avg_logprob": seg.avg_logprob,
+        "no_speech_prob": seg.no_speech_prob,
+        "words": [
+            {"word": w.word, "start": w.start, "end": w.end, "probability": w.probability}
+            for w in (seg.words or [])
+        ],
+    }
+
+def decode_options(req):
+    """Translate the request's decode options into model.transcribe keyword arguments."""
+    opts = req.get("decode")
+    if not opts:
+        return {}
+    # fp16 has no equivalent here; precision is fixed by --compute-type
+    return {
+        "beam_size": max(opts["beam_size"], 1),
+        "best_of": max(opts["best_of"], 1),
+        "temperature": opts["temperature"],
+        "condition_on_previous_text": opts["condition_on_previous_text"],
+        "compression_ratio_threshold": opts["compression_ratio_threshold"],
+        "no_speech_threshold": opts["no_speech_threshold"],
+    }
+
+def main():
+    parser = argparse.ArgumentParser(description='Transcribe audio using faster-whisper via IPC')
+    parser.add_argument('--device', default='cpu', help='Device to use (cpu or cuda)')
+    parser.add_argument('--model', default='small', help='Whisper model to use (tiny, base, small, medium, large-v3)')
+    parser.add_argument('--compute-type', default='default', help='CTranslate2 compute type (int8, int8_float16, float16, float32)')
+    args = parser.parse_args()
+
+    try:
+        model = WhisperModel(args.model, device=args.device, compute_type=args.compute_type)
+        ready(
+            model=args.model,
+            device=model.model.device,
+            # "default" resolves to whatever CTranslate2 picked for this device
+            dtype=model.model.compute_type,
+            versions={
+                "python": platform.python_version(),
+                "faster_whisper": faster_whisper.__version__,
+                "ctranslate2": ctranslate2.__version__,
+            },
+        )
+    except Exception as e:
+        send({"type": "ERROR", "error": str(e)})
+        sys.exit(1)
+
+    def transcribe(req_id, req, audio):
+        # Segments are generated lazily; decoding happens while we iterate, so progress
+        # is reported as each one arrives
+        segments, info = model.transcribe(
+            audio,
+            task=req.get("task") or "transcribe",
+            language=req.get("language") or None,
+            initial_prompt=req.get("initial_prompt") or None,
+            word_timestamps=bool(req.get("word_timestamps")),
+            **decode_options(req),
+        )
+        decoded = []
+        for seg in segments:
+            decoded.append(to_segment(seg))
+            send({
+                "type": "PROGRESS",
+                "id": req_id,
+                "processed": min(seg.end, info.duration),
+                "duration": info.duration,
+                "segments": [decoded[-1]],
+            })
+        return {
+            "text": " ".join(seg["text"] for seg in decoded if seg["text"]),
+            "segments": decoded,
+            "language": info.language,
+            "language_probability": info.language_probability if not req.get("language") else None,
+            "duration": info.duration,
+        }
+
+    serve(transcribe)
+
+if __name__ == "__main__":
+    main()
@@ -2,79 +2,265 @@ package whisper
 
 import (
 	"bufio"
+	"context"
 	_ "embed"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"time"
+
+	"whispergui/pcm"
 )
 
 //go:embed transcribe.py
 var transcribeScript []byte
 
-type Transcriber struct {
-	cmd        *exec.Cmd
-	stdin      io.WriteCloser
-	stdout     *bufio.Scanner
-	scriptPath string
-	mu         sync.Mutex
+//go:embed transcribe_faster.py
+var transcribeFasterScript []byte
+
+// workerProtocolModule holds the protocol code the worker scripts share; it is written
+// next to the script, where Python finds it for import.
+//
+//go:embed worker_protocol.py
+var workerProtocolModule []byte
+
+const workerScriptName = "worker.py"
+
+func init() {
+	Register("openai-whisper", func() Backend {
+		return &workerBackend{
+			script: transcribeScript,
+			caps: Capabilities{
+				Models:         []string{"tiny", "base", "small", "medium", "large"},
+				DefaultModel:   "small",
+				GPU:            true,
+				WordTimestamps: true,
+			},
+		}
+	})
+
+	// faster-whisper (CTranslate2) speaks the same protocol; int8 makes CPU-only machines usable
+	Register("faster-whisper", func() Backend {
+		return &workerBackend{
+			script: transcribeFasterScript,
+			caps: Capabilities{
+				Models:         []string{"tiny", "base", "small", "medium", "large-v3", "distil-large-v3"},
+				DefaultModel:   "small",
+				GPU:            true,
+				ComputeTypes:   []string{"int8", "int8_float16", "float16", "float32"},
+				WordTimestamps: true,
+			},
+		}
+	})
+}
+
+// workerBackend drives a Python worker script over a JSON-lines protocol on stdin/stdout.
+// All worker scripts take --model, --device and (if they list compute types) --compute-type.
+// The process is supervised and restarted with the same options if it dies.
+type workerBackend struct {
+	script    []byte
+	caps      Capabilities
+	opts      LoadOptions
+	scriptDir string // temporary directory holding the script and worker_protocol.py
+	sup       *supervisor
+	mu        sync.Mutex // serialises requests
+
+	connMu sync.Mutex // guards conn and info, which are replaced when the worker restarts
+	conn   *workerConn
+	info   BackendInfo // from the worker's READY message
 }
 
-var (
-	instance *Transcriber
-	initMu   sync.Mutex
-)
+// cancelGrace is how long a cancelled job may take to stop before the worker is restarted
+const cancelGrace = 10 * time.Second
 
-type whisperRequest struct {
-	AudioFile string `json:"audio_file"`
+// DefaultRequestTimeout is used when a Request has no Timeout.
+const DefaultRequestTimeout = 5 * time.Minute
+
+// workerStartTimeout is how long a starting worker may go without any output before it
+// is taken to have hung and killed. Model downloads print progress, so it only needs to
+// outlast the silent parts of loading.
+var workerStartTimeout = 10 * time.Minute
+
+func (t *workerBackend) Capabilities() Capabilities {
+	return t.caps
 }
 
-type whisperResponse struct {
-	Status string `json:"status"`
-	Text   string `json:"text,omitempty"`
-	Error  string `json:"error,omitempty"`
+func (t *workerBackend) Load(opts LoadOptions) error {
+	// Write the embedded Python script and the module it imports to a temporary directory
+	dir, err := os.MkdirTemp("", "whisper_worker_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory for script: %v", err)
+	}
+	t.scriptDir = dir
+	for name, data := range map[string][]byte{workerScriptName: t.script, "worker_protocol.py": workerProtocolModule} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return fmt.Errorf("failed to write temp script: %v", err)
+		}
+	}
+	t.opts = opts
+
+	t.sup = newSupervisor(t.start)
+	return t.sup.run()
 }
 
-func Init(useGPU bool, modelName string) error {
-	initMu.Lock()
-	defer initMu.Unlock()
+// start launches the worker with the current options and waits until its model is loaded.
+// It is called again by the supervisor to restart a worker that died.
+func (t *workerBackend) start() (*exec.Cmd, error) {
+	opts := t.opts
+	args := []string{filepath.Join(t.scriptDir, workerScriptName), "--model", opts.Model}
+	if opts.UseGPU {
+		args = append(args, "--device", "cuda")
+	}
+	if opts.ComputeType != "" {
+		args = append(args, "--compute-type", opts.ComputeType)
+	}
+
+	cmd := exec.Command(findPython(), args...)
 
-	// Shut down any existing instance
-	if instance != nil {
-		Close()
-		instance = nil
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
 	}
 
-	var err error
-	instance, err = newTranscriber(useGPU, modelName)
-	return err
-}
+	// Our own pipe rather than StdoutPipe, whose reader cmd.Wait would close under a
+	// reader still draining the last lines of a worker that crashed
+	stdoutFile, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdoutW
 
-func newTranscriber(useGPU bool, modelName string) (*Transcriber, error) {
-	// Write the embedded Python script to a temporary file
-	tmpFile, err := os.CreateTemp("", "whisper_transcribe_*.py")
+	// Stderr is shown as it is, and its last line kept as the likely reason for a crash
+	t.sup.stderr.reset()
+	activity := make(activityWriter, 1)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &t.sup.stderr, activity)
+
+	err = cmd.Start()
+	stdoutW.Close()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file for script: %v", err)
+		stdoutFile.Close()
+		return nil, err
 	}
-	if err := os.WriteFile(tmpFile.Name(), transcribeScript, 0644); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpFile.Name())
-		return nil, fmt.Errorf("failed to write temp script: %v", err)
+
+	// Create a scanner for reading JSON lines from stdout
+	stdout := bufio.NewScanner(stdoutFile)
+	stdout.Buffer(make([]byte, 0, 64*1024), maxResponseSize)
+
+	// A load that hangs, e.g. in CUDA initialisation, is killed so it counts as a failed start
+	hung := make(chan bool, 1)
+	ready := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(workerStartTimeout)
+		defer timer.Stop()
+		for {
+			select {
+			case <-activity:
+				timer.Reset(workerStartTimeout)
+			case <-ready:
+				hung <- false
+				return
+			case <-timer.C:
+				cmd.Process.Kill()
+				hung <- true
+				return
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	var timedOut bool
+	stopWatch := func() bool {
+		stopOnce.Do(func() {
+			close(ready)
+			timedOut = <-hung
+		})
+		return timedOut
 	}
-	tmpFile.Close()
-	scriptPath := tmpFile.Name()
+	errHung := fmt.Errorf("python process hung while loading the model (no output for %s)", workerStartTimeout)
 
-	args := []string{scriptPath, "--model", modelName}
-	if useGPU {
-		args = append(args, "--device", "cuda")
+	fail := func(err error) (*exec.Cmd, error) {
+		stopWatch()
+		cmd.Process.Kill()
+		cmd.Wait()
+		stdoutFile.Close()
+		return nil, err
+	}
+
+	// Wait for the READY signal; LOG messages may come first while the model loads
+	for {
+		if !stdout.Scan() {
+			if stopWatch() {
+				return fail(errHung)
+			}
+			cmd.Wait()
+			stdoutFile.Close()
+			return nil, fmt.Errorf("python process exited unexpectedly (%s)", exitReason(cmd.ProcessState, nil, t.sup.stderr.String()))
+		}
+		activity.Write(nil)
+		msg, ok := readWorkerMessage(stdout.Bytes())
+		if !ok {
+			continue
+		}
+		if msg.Type == msgError {
+			return fail(fmt.Errorf("python process failed to initialize: %s", msg.Error))
+		}
+		if msg.Type != msgReady {
+			return fail(fmt.Errorf("expected READY from python process, got %s", msg.Type))
+		}
+		if msg.Protocol != protocolVersion {
+			return fail(fmt.Errorf("worker speaks protocol version %d, but version %d is required", msg.Protocol, protocolVersion))
+		}
+
+		t.connMu.Lock()
+		t.info = BackendInfo{
+			Model:    msg.Model,
+			Device:   msg.Device,
+			DType:    msg.DType,
+			Protocol: msg.Protocol,
+			Versions: msg.Versions,
+			Options:  msg.Options,
+		}
+		t.connMu.Unlock()
+		break
 	}
+	if stopWatch() {
+		// Killed just as READY arrived
+		return fail(errHung)
+	}
+
+	// Close may have run while the model loaded; it clears the conn under connMu after
+	// stopping the supervisor, so one installed now would never be closed
+	t.connMu.Lock()
+	if t.sup.currentState() == WorkerStopped {
+		t.connMu.Unlock()
+		return fail(errors.New("worker was stopped while starting"))
+	}
+	if t.conn != nil {
+		t.conn.stdoutFile.Close()
+	}
+	t.conn = newWorkerConn(stdin, stdoutFile, stdout)
+	t.connMu.Unlock()
+	return cmd, nil
+}
+
+// activityWriter signals on its channel whenever it is written to.
+type activityWriter chan struct{}
 
-	// Resolve the Python executable, checking for a local .venv first
+func (w activityWriter) Write(p []byte) (int, error) {
+	select {
+	case w <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// findPython resolves the Python executable, checking PYTHON_ENV and a local .venv first
+func findPython() string {
 	pythonExec := "python3"
 
 	if envPath := os.Getenv("PYTHON_ENV"); envPath != "" {
@@ -119,95 +305,204 @@ func newTranscriber(useGPU bool, modelName string) (*Transcriber, error) {
 		}
 	}
 
-	cmd := exec.Command(pythonExec, args...)
+	return pythonExec
+}
 
-	stdin, err := cmd.StdinPipe()
+// Transcribe sends r to the worker, first waiting for it to come back if it is being
+// restarted. Cancelling ctx interrupts the worker, which abandons the job and keeps its
+// model loaded; a worker that does not stop in time, or goes quiet for longer than the
+// request timeout, is restarted.
+//
+// Requests carry ids, so answers cannot be mixed up, but they are still sent one at a
+// time: the worker decodes sequentially, and the timeout and SIGINT cancellation both
+// assume the job in flight is the caller's.
+func (t *workerBackend) Transcribe(ctx context.Context, r Request) (Result, error) {
+	// Done before queueing for the worker, so a long recording holds up no other request
+	samples, err := t.samples(ctx, r)
 	if err != nil {
-		return nil, err
+		return Result{}, err
+	}
+
+	req := workerRequest{
+		AudioFile:      r.AudioFile,
+		Task:           r.Task,
+		Language:       r.Language,
+		InitialPrompt:  r.InitialPrompt,
+		WordTimestamps: r.WordTimestamps,
+		Decode:         r.Decode,
+	}
+
+	var payload []byte
+	if samples != nil {
+		req.AudioFile = ""
+		req.PCM, payload = encodePCM(samples)
 	}
 
-	stdoutPipe, err := cmd.StdoutPipe()
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	msg, err := t.request(ctx, req, payload, requestOptions(r), timeout, r.OnProgress)
 	if err != nil {
-		return nil, err
+		return Result{}, err
 	}
 
-	// Create a scanner for reading JSON lines from stdout
-	stdout := bufio.NewScanner(stdoutPipe)
+	return Result{
+		Text:                strings.TrimSpace(msg.Text),
+		Segments:            msg.Segments,
+		Language:            msg.Language,
+		LanguageProbability: msg.LanguageProbability,
+		Duration:            msg.Duration,
+	}, nil
+}
 
-	// We also want to capture stderr in case of catastrophic failure
-	cmd.Stderr = os.Stderr
+// request sends req to the worker once it is ready and waits for the answer, as described
+// for Transcribe. options are the request fields the worker has to support.
+func (t *workerBackend) request(ctx context.Context, req workerRequest, payload []byte, options []string, timeout time.Duration, onProgress func(Progress)) (workerMessage, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if err := cmd.Start(); err != nil {
-		return nil, err
+	exited, err := t.sup.waitReady(ctx)
+	if err != nil {
+		return workerMessage{}, err
 	}
+	t.connMu.Lock()
+	conn, info := t.conn, t.info
+	t.connMu.Unlock()
 
-	t := &Transcriber{
-		cmd:        cmd,
-		stdin:      stdin,
-		stdout:     stdout,
-		scriptPath: scriptPath,
+	for _, opt := range options {
+		if !slices.Contains(info.Options, opt) {
+			return workerMessage{}, fmt.Errorf("this worker does not support the %q option", opt)
+		}
 	}
 
-	// Wait for the READY signal
-	if !stdout.Scan() {
-		return nil, fmt.Errorf("python process exited unexpectedly")
+	call, err := conn.send(req, payload)
+	if err != nil {
+		return workerMessage{}, t.exitError(err, exited)
 	}
-
-	var resp whisperResponse
-	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
-		return nil, fmt.Errorf("failed to parse ready signal: %v (raw: %s)", err, stdout.Text())
+	defer conn.forget(call)
+
+	// Every message from the worker shows it is alive and restarts the timeout
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var msg workerMessage
+	for waiting := true; waiting; {
+		select {
+		case p := <-call.progress:
+			timer.Reset(timeout)
+			if onProgress != nil {
+				onProgress(p)
+			}
+		case msg = <-call.final:
+			waiting = false
+		case <-conn.closed:
+			// The answer may have been dispatched just before stdout ended
+			select {
+			case msg = <-call.final:
+				waiting = false
+				continue
+			default:
+			}
+			return workerMessage{}, t.exitError(errors.New("failed to read response from python process"), exited)
+		case <-ctx.Done():
+			t.cancel(conn, call, exited)
+			return workerMessage{}, ctx.Err()
+		case <-timer.C:
+			reason := fmt.Sprintf("no response for %s", timeout)
+			t.sup.restart(reason)
+			<-exited // so the next request waits for the new process
+			return workerMessage{}, fmt.Errorf("transcription timed out (%s); the worker is being restarted", reason)
+		}
 	}
 
-	if resp.Status != "READY" {
-		return nil, fmt.Errorf("python process failed to initialize: %s", resp.Error)
+	if msg.Type == msgError {
+		if msg.Code == errCodeCancelled {
+			// Interrupted from outside, e.g. Ctrl+C in the terminal
+			return workerMessage{}, context.Canceled
+		}
+		return workerMessage{}, errors.New(msg.Error)
 	}
-
-	return t, nil
+	return msg, nil
 }
 
-func Transcribe(audioPath string, useGPU bool) (string, error) {
-	if instance == nil {
-		return "", errors.New("whisper not initialized")
+// streamPass implements streamer. The worker keeps the window, so only the audio that
+// arrived since the last pass is sent.
+func (t *workerBackend) streamPass(ctx context.Context, frame streamFrame, samples []float32) (final, provisional []Segment, err error) {
+	req := workerRequest{Stream: &frame}
+	var payload []byte
+	if len(samples) > 0 {
+		req.PCM, payload = encodePCM(samples)
 	}
+	msg, err := t.request(ctx, req, payload, []string{OptionStream}, DefaultRequestTimeout, nil)
+	return msg.Segments, msg.Provisional, err
+}
 
-	instance.mu.Lock()
-	defer instance.mu.Unlock()
-
-	req := whisperRequest{AudioFile: audioPath}
-	reqData, err := json.Marshal(req)
-	if err != nil {
-		return "", err
+// samples returns the audio to send as PCM, or nil to send r.AudioFile by path. WAV
+// files, which is what the recorder writes, are read here when the worker takes PCM, so
+// it needs neither ffmpeg nor the disk; other formats still go by path.
+func (t *workerBackend) samples(ctx context.Context, r Request) ([]float32, error) {
+	if r.Samples != nil || !slices.Contains(t.Info().Options, OptionPCM) || !strings.EqualFold(filepath.Ext(r.AudioFile), ".wav") {
+		return r.Samples, nil
 	}
-
-	// Send request with newline
-	_, err = instance.stdin.Write(append(reqData, '\n'))
+	s, rate, err := pcm.ReadWav(r.AudioFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %v", err)
+		return nil, nil // the worker may still manage to decode it
 	}
+	return pcm.ResampleContext(ctx, s, rate, SampleRate)
+}
 
-	// Read response
-	if !instance.stdout.Scan() {
-		return "", errors.New("failed to read response from python process")
-	}
+func (t *workerBackend) Info() BackendInfo {
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	return t.info
+}
 
-	var resp whisperResponse
-	if err := json.Unmarshal(instance.stdout.Bytes(), &resp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
+// exitError replaces a failed read or write with the reason the worker died, if it did.
+func (t *workerBackend) exitError(err error, exited <-chan struct{}) error {
+	select {
+	case <-exited:
+		return fmt.Errorf("python process exited (%s); restarting it", t.sup.lastExit())
+	case <-time.After(2 * time.Second):
+		return err
 	}
+}
 
-	if resp.Status == "ERROR" {
-		return "", errors.New(resp.Error)
+// cancel interrupts call. The worker answers it with a cancelled ERROR and carries on;
+// if it cannot be signalled or does not answer in time it is killed and the supervisor
+// starts it again with the same model.
+func (t *workerBackend) cancel(conn *workerConn, call *workerCall, exited <-chan struct{}) {
+	if err := t.sup.signal(os.Interrupt); err == nil {
+		select {
+		case <-call.final:
+			return
+		case <-conn.closed:
+			return
+		case <-time.After(cancelGrace):
+		}
 	}
 
-	return strings.TrimSpace(resp.Text), nil
+	t.sup.restart("cancelled job did not stop")
+	<-exited
 }
 
-func Close() {
-	if instance != nil && instance.cmd != nil {
-		instance.stdin.Close()
-		instance.cmd.Wait() // wait for graceful exit
-		if instance.scriptPath != "" {
-			os.Remove(instance.scriptPath)
-		}
+func (t *workerBackend) Close() {
+	if t.sup != nil {
+		// Closing stdin ends the worker's request loop
+		t.sup.stop(func() {
+			t.connMu.Lock()
+			t.conn.stdin.Close()
+			t.connMu.Unlock()
+		})
+	}
+	t.connMu.Lock()
+	if t.conn != nil {
+		t.conn.stdoutFile.Close()
+		t.conn = nil
+	}
+	t.connMu.Unlock()
+	if t.scriptDir != "" {
+		os.RemoveAll(t.scriptDir)
+		t.scriptDir = ""
 	}
 }
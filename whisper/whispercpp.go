@@ -0,0 +1,277 @@
+package whisper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"whispergui/pcm"
+)
+
+const cppStartupTimeout = 2 * time.Minute
+
+func init() {
+	Register("whisper.cpp", func() Backend { return &cppBackend{} })
+}
+
+// cppBackend drives a local whisper.cpp server binary with a GGML model file. The
+// server keeps the model loaded between requests, which the one-shot CLI would not.
+//
+// The binary is taken from WHISPER_CPP_SERVER or "whisper-server" on the PATH, and
+// models are looked up as ggml-<name>.bin in WHISPER_CPP_MODELS (default
+// ~/.cache/whisper.cpp).
+type cppBackend struct {
+	opts    LoadOptions
+	cmd     *exec.Cmd
+	url     string
+	exited  chan struct{}
+	convert bool // the server was started with --convert and decodes other formats with ffmpeg
+	mu      sync.Mutex
+}
+
+// cppResponse is the verbose_json output of the server's /inference endpoint.
+type cppResponse struct {
+	Text     string    `json:"text"`
+	Language string    `json:"language"`
+	Duration float64   `json:"duration"`
+	Segments []Segment `json:"segments"`
+	Error    string    `json:"error,omitempty"`
+}
+
+func cppModelDir() string {
+	if dir := os.Getenv("WHISPER_CPP_MODELS"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".cache", "whisper.cpp")
+	}
+	return "models"
+}
+
+func (b *cppBackend) Capabilities() Capabilities {
+	caps := Capabilities{GPU: true}
+
+	// Offer the models that are actually downloaded, falling back to the standard names
+	matches, _ := filepath.Glob(filepath.Join(cppModelDir(), "ggml-*.bin"))
+	for _, m := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), "ggml-"), ".bin")
+		caps.Models = append(caps.Models, name)
+	}
+	sort.Strings(caps.Models)
+	if len(caps.Models) == 0 {
+		caps.Models = []string{"tiny", "base", "small", "medium", "large-v3"}
+	}
+
+	caps.DefaultModel = caps.Models[0]
+	for _, m := range caps.Models {
+		if m == "base" {
+			caps.DefaultModel = m
+		}
+	}
+	return caps
+}
+
+func (b *cppBackend) Load(opts LoadOptions) error {
+	binary := os.Getenv("WHISPER_CPP_SERVER")
+	if binary == "" {
+		path, err := exec.LookPath("whisper-server")
+		if err != nil {
+			return errors.New("whisper.cpp server not found; install whisper-server or set WHISPER_CPP_SERVER")
+		}
+		binary = path
+	}
+
+	modelPath := filepath.Join(cppModelDir(), "ggml-"+opts.Model+".bin")
+	if _, err := os.Stat(modelPath); err != nil {
+		return fmt.Errorf("model file not found: %s", modelPath)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-m", modelPath, "--host", "127.0.0.1", "--port", strconv.Itoa(port)}
+	if !opts.UseGPU {
+		args = append(args, "--no-gpu")
+	}
+	// The server reads only WAV by itself; with --convert it runs anything else through ffmpeg
+	_, err = exec.LookPath("ffmpeg")
+	convert := err == nil
+	if convert {
+		args = append(args, "--convert")
+	}
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = os.Stderr // the server logs to stdout; keep it off our own output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	b.opts = opts
+	b.convert = convert
+	b.cmd = cmd
+	b.url = fmt.Sprintf("http://127.0.0.1:%d", port)
+	b.exited = make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(b.exited)
+	}()
+
+	// The server only starts listening once the model is loaded
+	deadline := time.After(cppStartupTimeout)
+	for {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-b.exited:
+			return errors.New("whisper.cpp server exited unexpectedly")
+		case <-deadline:
+			return errors.New("timed out waiting for whisper.cpp server to load the model")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// Transcribe posts the recording to the server. A cancelled request is dropped by the
+// server once it notices the closed connection.
+func (b *cppBackend) Transcribe(ctx context.Context, r Request) (Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// WAV files must be 16 kHz for the server, so they are resampled here; other formats
+	// go as they are, for the server to convert
+	audioPath := r.AudioFile
+	if ext := filepath.Ext(r.AudioFile); strings.EqualFold(ext, ".wav") {
+		path, err := convertForWhisper(r.AudioFile)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to prepare audio: %v", err)
+		}
+		defer os.Remove(path)
+		audioPath = path
+	} else if !b.convert {
+		return Result{}, fmt.Errorf("whisper.cpp needs ffmpeg installed to read %s files", ext)
+	}
+
+	language := r.Language
+	if language == "" {
+		language = "auto"
+	}
+	fields := map[string][]string{
+		"response_format": {"verbose_json"},
+		"language":        {language},
+	}
+	if r.Task == TaskTranslate {
+		fields["translate"] = []string{"true"}
+	}
+	if r.InitialPrompt != "" {
+		fields["prompt"] = []string{r.InitialPrompt}
+	}
+	if d := r.Decode; d != nil {
+		// The server takes a start temperature and a fixed increment instead of a schedule
+		fields["beam_size"] = []string{strconv.Itoa(d.BeamSize)}
+		fields["best_of"] = []string{strconv.Itoa(d.BestOf)}
+		if len(d.Temperature) > 0 {
+			fields["temperature"] = []string{strconv.FormatFloat(d.Temperature[0], 'f', -1, 64)}
+			inc := 0.0
+			if len(d.Temperature) > 1 {
+				inc = d.Temperature[1] - d.Temperature[0]
+			}
+			fields["temperature_inc"] = []string{strconv.FormatFloat(inc, 'f', -1, 64)}
+		}
+	}
+
+	raw, err := postAudio(ctx, http.DefaultClient, b.url+"/inference", "", audioPath, fields)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var resp cppResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return Result{}, fmt.Errorf("failed to parse response: %v", err)
+	}
+	if resp.Error != "" {
+		return Result{}, errors.New(resp.Error)
+	}
+
+	return Result{
+		Text:     strings.TrimSpace(resp.Text),
+		Segments: resp.Segments,
+		Language: resp.Language,
+		Duration: resp.Duration,
+	}, nil
+}
+
+func (b *cppBackend) Info() BackendInfo {
+	device := "cpu"
+	if b.opts.UseGPU {
+		device = "gpu"
+	}
+	return BackendInfo{
+		Model:   filepath.Join(cppModelDir(), "ggml-"+b.opts.Model+".bin"),
+		Device:  device + " (" + b.url + ")",
+		Options: []string{OptionTask, OptionLanguage, OptionInitialPrompt, OptionDecode, OptionCancel},
+	}
+}
+
+func (b *cppBackend) Close() {
+	if b.cmd != nil {
+		b.cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-b.exited:
+		case <-time.After(3 * time.Second):
+			b.cmd.Process.Kill()
+			<-b.exited
+		}
+		b.cmd = nil
+	}
+}
+
+// convertForWhisper writes a 16 kHz 16-bit copy of a WAV file to a temp file and returns its path.
+func convertForWhisper(path string) (string, error) {
+	samples, rate, err := pcm.ReadWav(path)
+	if err != nil {
+		return "", err
+	}
+	return writeTempWav(pcm.Resample(samples, rate, SampleRate))
+}
+
+// writeTempWav writes samples at SampleRate to a temp file and returns its path.
+func writeTempWav(samples []float32) (string, error) {
+	tmpFile, err := os.CreateTemp("", "whisper_16k_*.wav")
+	if err != nil {
+		return "", err
+	}
+	tmpFile.Close()
+
+	if err := pcm.WriteWav(tmpFile.Name(), samples, SampleRate); err != nil {
+		os.Remove(tmpFile.Name())
+		return "", err
+	}
+	return tmpFile.Name(), nil
+}
+
+// freePort asks the OS for an unused local TCP port
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,245 @@
+"""The stdin/stdout protocol shared by the worker scripts. The app writes this module
+next to the worker script it runs, which imports it."""
+
+import json
+import signal
+import sys
+import warnings
+
+import numpy as np
+
+# Version of the stdin/stdout protocol; the app refuses a worker that speaks another
+PROTOCOL_VERSION = 1
+
+# Request fields the workers honour, reported in READY
+OPTIONS = ["task", "language", "initial_prompt", "word_timestamps", "decode", "progress", "cancel", "pcm", "stream"]
+
+def send(msg):
+    """Write one protocol message. A single write keeps the line whole if SIGINT arrives."""
+    sys.stdout.write(json.dumps(msg) + "\n")
+    sys.stdout.flush()
+
+def log(level, message):
+    send({"type": "LOG", "level": level, "message": message})
+
+# Warnings (such as "FP16 is not supported on CPU") become LOG messages
+warnings.showwarning = lambda message, category, *args, **kwargs: log("warning", str(message))
+
+def ready(model, device, dtype, versions):
+    """Announce that the model is loaded and requests are accepted."""
+    send({
+        "type": "READY",
+        "protocol": PROTOCOL_VERSION,
+        "model": model,
+        "device": device,
+        "dtype": dtype,
+        "versions": versions,
+        "options": OPTIONS,
+    })
+
+# Raw audio arrives as a pcm field on the request line, followed by exactly that many bytes
+# of mono 16 kHz samples
+PCM_DTYPES = {"f32le": "<f4", "s16le": "<i2"}
+
+def read_pcm(frame):
+    """Read the samples announced by a request's pcm field from stdin, as float32."""
+    n = frame["bytes"]
+    raw = sys.stdin.buffer.read(n)
+    if len(raw) != n:
+        raise EOFError("stdin closed in the middle of a PCM frame")
+    dtype = PCM_DTYPES.get(frame["format"])
+    if dtype is None:
+        raise ValueError("unsupported PCM format: %s" % frame["format"])
+    samples = np.frombuffer(raw, dtype=dtype).astype(np.float32)
+    if frame["format"] == "s16le":
+        samples /= 32768.0
+    return samples
+
+SAMPLE_RATE = 16000
+
+# Sliding window for streams, in seconds of audio
+STREAM_MAX_WINDOW = 20.0  # once the window is this long, settled segments are made final
+STREAM_HOLD = 5.0         # segments ending this close to the live edge stay provisional
+STREAM_FORCE = 28.0       # Whisper sees 30 s at a time; past this the window is committed regardless
+STREAM_OVERLAP = 1.0      # audio kept before the committed point, as context for the next pass
+
+STREAM_PROMPT_CHARS = 200  # committed text passed on as the initial prompt
+MAX_REPEAT_WORDS = 8       # longest run of words recognised as transcribed twice
+
+class StreamWindow:
+    """The audio of a live stream that is still being decoded. Each pass decodes the whole
+    window; segments far enough from the live edge are made final and the window moves
+    past them, keeping a little audio before that point as context. Text the overlap
+    causes to be transcribed twice is dropped."""
+
+    def __init__(self, offset):
+        self.audio = np.zeros(0, dtype=np.float32)
+        self.start = offset                        # stream position of audio[0]
+        self.committed_end = offset / SAMPLE_RATE  # end of the last final segment, in seconds
+        self.committed = ""                        # final text so far
+
+    def feed(self, samples, offset):
+        # Audio the app failed to deliver leaves a gap; the window starts again after it
+        if offset != self.start + len(self.audio):
+            self.audio = np.zeros(0, dtype=np.float32)
+            self.start = offset
+        self.audio = np.concatenate([self.audio, samples])
+
+    def decode_pass(self, decode, last):
+        """Decode the window with decode(audio, prompt), which returns segments timed from
+        the start of audio. Returns the segments made final and the provisional ones after
+        them, in stream time; with last set everything is made final."""
+        if len(self.audio) == 0:
+            return [], []
+        base = self.start / SAMPLE_RATE
+        length = len(self.audio) / SAMPLE_RATE
+
+        try:
+            segments = decode(self.audio, tail(self.committed, STREAM_PROMPT_CHARS))
+        except Exception:
+            # A full window is given up on, so passes that keep failing cannot grow it
+            if length >= STREAM_FORCE:
+                self.advance(base + length - STREAM_OVERLAP)
+            raise
+        segments = self.fresh(segments, base)
+
+        commit = 0
+        if last or length >= STREAM_FORCE:
+            commit = len(segments)
+        elif length >= STREAM_MAX_WINDOW:
+            edge = base + length - STREAM_HOLD
+            while commit < len(segments) and segments[commit]["end"] <= edge:
+                commit += 1
+        for seg in segments[:commit]:
+            self.committed_end = seg["end"]
+            self.committed = (self.committed + " " + seg["text"]).strip()
+
+        # Move past what is final, or past the whole window if it is full but produced
+        # nothing final (silence, or speech Whisper could not make out)
+        start = self.committed_end - STREAM_OVERLAP
+        if length >= STREAM_FORCE:
+            start = max(start, base + length - STREAM_OVERLAP)
+        self.advance(start)
+        return segments[:commit], segments[commit:]
+
+    def advance(self, seconds):
+        """Start the window at stream time seconds, dropping the audio before it."""
+        cut = int(seconds * SAMPLE_RATE) - self.start
+        if cut > 0:
+            self.audio = self.audio[cut:]
+            self.start += cut
+
+    def fresh(self, segments, base):
+        """Shift segments to stream time and drop what was already made final: segments
+        ending inside the committed audio, and the words at the start of the first one
+        that repeat the end of the committed text."""
+        out = []
+        for seg in segments:
+            # Word times would need shifting too, and streams do not ask for them
+            seg = dict(seg, start=seg["start"] + base, end=seg["end"] + base, words=[])
+            if seg["end"] <= self.committed_end:
+                continue
+            if not out and seg["start"] < self.committed_end + STREAM_OVERLAP:
+                seg["text"] = trim_repeat(self.committed, seg["text"])
+            seg["text"] = seg["text"].strip()
+            if seg["text"]:
+                out.append(seg)
+        return out
+
+def trim_repeat(committed, text):
+    """Remove from the start of text the longest run of words that also ends committed,
+    ignoring case and punctuation."""
+    prev = [normalize_word(w) for w in committed.split()]
+    words = text.split()
+    for n in range(min(len(prev), len(words), MAX_REPEAT_WORDS), 0, -1):
+        if prev[len(prev) - n:] == [normalize_word(w) for w in words[:n]]:
+            return " ".join(words[n:])
+    return text
+
+def normalize_word(w):
+    i, j = 0, len(w)
+    while i < j and not w[i].isalnum():
+        i += 1
+    while j > i and not w[j - 1].isalnum():
+        j -= 1
+    return w[i:j].lower()
+
+def tail(s, n):
+    """Return about the last n characters of s, starting at a word boundary."""
+    if len(s) <= n:
+        return s
+    s = s[len(s) - n:]
+    i = s.find(" ")
+    return s[i + 1:] if i >= 0 else s
+
+def stream_pass(windows, frame, audio, decode):
+    """Run one pass of the stream named by a request's stream field and return the fields
+    of its RESULT. windows holds the open streams by id."""
+    if frame.get("drop"):
+        windows.pop(frame["id"], None)
+        return {}
+    if isinstance(audio, str):
+        raise ValueError("stream audio must be sent as pcm")
+
+    window = windows.get(frame["id"])
+    if window is None:
+        window = windows[frame["id"]] = StreamWindow(frame["offset"])
+    if audio is not None:
+        window.feed(audio, frame["offset"])
+    try:
+        final, provisional = window.decode_pass(decode, frame.get("end", False))
+    finally:
+        if frame.get("end"):
+            del windows[frame["id"]]
+    return {"segments": final, "provisional": provisional}
+
+# SIGINT cancels the job in progress. It is ignored between jobs, so an interrupt that
+# arrives just after a response was sent cannot kill the worker or produce a second reply.
+busy = False
+
+def on_interrupt(signum, frame):
+    if busy:
+        raise KeyboardInterrupt
+
+def serve(transcribe):
+    """Answer requests from stdin until it closes. transcribe(req_id, req, audio) returns
+    the fields of the RESULT message; audio is float32 samples or a file path. If it
+    raises, or SIGINT interrupts it, the request is answered with ERROR instead.
+
+    Requests with a stream field are passes over a live stream, whose window is kept
+    here and decoded with transcribe."""
+    global busy
+    signal.signal(signal.SIGINT, on_interrupt)
+    windows = {}
+
+    for line in sys.stdin.buffer:
+        req_id = 0
+        try:
+            req = json.loads(line)
+            req_id = req.get("id", 0)
+            # Read any samples in full before SIGINT can interrupt, or the rest of the frame
+            # would be taken for the next request
+            audio = read_pcm(req["pcm"]) if req.get("pcm") else req.get("audio_file")
+        except Exception as e:
+            send({"type": "ERROR", "id": req_id, "error": str(e)})
+            continue
+        stream = req.get("stream")
+        if stream is None and (audio is None or (isinstance(audio, str) and not audio)):
+            send({"type": "ERROR", "id": req_id, "error": "Request has neither pcm nor audio_file"})
+            continue
+
+        busy = True
+        try:
+            if stream is None:
+                result = transcribe(req_id, req, audio)
+            else:
+                decode = lambda window, prompt: transcribe(req_id, dict(req, initial_prompt=prompt), window)["segments"]
+                result = stream_pass(windows, stream, audio, decode)
+            busy = False
+            send({"type": "RESULT", "id": req_id, **result})
+        except KeyboardInterrupt:
+            busy = False
+            send({"type": "ERROR", "id": req_id, "code": "cancelled", "error": "cancelled"})
+        except Exception as e:
+            busy = False
+            send({"type": "ERROR", "id": req_id, "error": str(e)})